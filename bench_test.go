@@ -0,0 +1,253 @@
+package lesson4
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"lesson4/datagen"
+	"lesson4/schema"
+)
+
+// Бенчмарки поиска на сгенерированных датасетах разного размера:
+//
+//	go test -run XXX -bench . -benchmem
+//
+// С -short миллионный датасет пропускается: его генерация и файлы занимают больше всего времени
+
+var benchSizes = []struct {
+	name string
+	rows int
+}{
+	{"1k", 1000},
+	{"100k", 100000},
+	{"1M", 1000000},
+}
+
+var (
+	benchMu      sync.Mutex
+	benchDataset = map[int][]Row{}
+)
+
+// benchRows - датасет из size записей, один на все бенчмарки. Записи перемешаны,
+// чтобы сортировка по Id не получала уже отсортированный вход
+func benchRows(b *testing.B, size int) []Row {
+	b.Helper()
+	if size >= 1000000 && testing.Short() {
+		b.Skip("1M rows in -short mode")
+	}
+	benchMu.Lock()
+	defer benchMu.Unlock()
+	if rows, ok := benchDataset[size]; ok {
+		return rows
+	}
+
+	config := datagen.DefaultConfig
+	config.Seed = 1
+	config.Rows = size
+	config.NameDuplicateRate = 0.1
+	config.AboutDuplicateRate = 0.1
+	rows := datagen.Generate(config)
+	rng := rand.New(rand.NewSource(1))
+	rng.Shuffle(len(rows), func(i, j int) { rows[i], rows[j] = rows[j], rows[i] })
+	benchDataset[size] = rows
+	return rows
+}
+
+// benchSearch прогоняет searchUsers по всем размерам датасета
+func benchSearch(b *testing.B, params searchParams) {
+	for _, size := range benchSizes {
+		b.Run(size.name, func(b *testing.B) {
+			rows := benchRows(b, size.rows)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, sErr := searchUsers(context.Background(), rows, params); sErr != nil {
+					b.Fatalf("search failed - %s", sErr.message)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkLoadRows(b *testing.B) {
+	defer func(old string) { datasetPath = old }(datasetPath)
+	for _, size := range benchSizes {
+		for _, format := range datagen.Formats {
+			b.Run(size.name+"/"+string(format), func(b *testing.B) {
+				rows := benchRows(b, size.rows)
+				datasetPath = b.TempDir() + "/dataset." + string(format)
+				if err := datagen.WriteFile(datasetPath, rows); err != nil {
+					b.Fatalf("cant write dataset - %s", err)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := loadRows(); err != nil {
+						b.Fatalf("cant load dataset - %s", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkQuery - поиск без сортировки: пустой запрос, частое слово из About и строка,
+// которой нет нигде, поэтому просматриваются все записи целиком
+func BenchmarkQuery(b *testing.B) {
+	for _, query := range []struct{ name, query string }{
+		{"empty", ""},
+		{"common", "lorem"},
+		{"miss", "no such user"},
+	} {
+		b.Run(query.name, func(b *testing.B) {
+			benchSearch(b, searchParams{limit: 25, query: query.query})
+		})
+	}
+}
+
+func BenchmarkSort(b *testing.B) {
+	for _, orderField := range []string{"id", "age", "name"} {
+		for _, orderBy := range []int{1, -1} {
+			b.Run(orderField+"/"+strconv.Itoa(orderBy), func(b *testing.B) {
+				benchSearch(b, searchParams{limit: 25, orderField: orderField, orderBy: orderBy})
+			})
+		}
+	}
+}
+
+// BenchmarkPaginate - страница с начала, из середины и с конца результата
+func BenchmarkPaginate(b *testing.B) {
+	for _, size := range benchSizes {
+		for _, page := range []struct {
+			name   string
+			offset int
+		}{
+			{"first", 0},
+			{"middle", size.rows / 2},
+			{"last", size.rows - 25},
+		} {
+			b.Run(size.name+"/"+page.name, func(b *testing.B) {
+				rows := benchRows(b, size.rows)
+				params := searchParams{limit: 25, offset: page.offset, orderField: "id", orderBy: 1}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					searchUsers(context.Background(), rows, params)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkEncode - json-ответ v2 для обычной страницы и для всего датасета
+func BenchmarkEncode(b *testing.B) {
+	for _, size := range benchSizes {
+		b.Run(size.name, func(b *testing.B) {
+			rows := benchRows(b, size.rows)
+			for _, page := range []struct {
+				name  string
+				users int
+			}{
+				{"page", 25},
+				{"all", len(rows)},
+			} {
+				b.Run(page.name, func(b *testing.B) {
+					users, _ := searchUsers(context.Background(), rows, searchParams{limit: page.users})
+					result := SearchResponse{Users: users}
+					b.ReportAllocs()
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						data, err := json.Marshal(result)
+						if err != nil {
+							b.Fatalf("cant encode - %s", err)
+						}
+						b.SetBytes(int64(len(data)))
+					}
+				})
+			}
+		})
+	}
+}
+
+// cannedTransport отвечает одним и тем же телом без сети, чтобы в бенчмарке клиента был только сам клиент
+type cannedTransport struct {
+	contentType string
+	body        []byte
+}
+
+func (c *cannedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": {c.contentType}},
+		Body:          ioutil.NopCloser(bytes.NewReader(c.body)),
+		ContentLength: int64(len(c.body)),
+		Request:       req,
+	}, nil
+}
+
+// BenchmarkFindUsersDecode - разбор ответа для обеих версий API, когда страница - весь датасет.
+// find собирает страницу в FindUsers, each отдаёт пользователей в FindUsersEach, не собирая их
+func BenchmarkFindUsersDecode(b *testing.B) {
+	for _, size := range benchSizes {
+		for _, version := range []string{APIVersionV1, APIVersionV2} {
+			for _, mode := range []string{"find", "each"} {
+				b.Run(size.name+"/"+version+"/"+mode, func(b *testing.B) {
+					rows := benchRows(b, size.rows)
+					users, _ := searchUsers(context.Background(), rows, searchParams{})
+
+					transport := &cannedTransport{contentType: schema.MediaTypeV2}
+					var err error
+					if version == APIVersionV2 {
+						transport.body, err = json.Marshal(SearchResponse{Users: users})
+					} else {
+						transport.contentType = schema.MediaTypeJSON
+						transport.body, err = json.Marshal(users)
+					}
+					if err != nil {
+						b.Fatalf("cant encode - %s", err)
+					}
+
+					rules := DefaultValidationRules
+					rules.MaxLimit = len(users)
+					client := &SearchClient{
+						AccessToken: "TestToken",
+						URL:         "http://search.invalid",
+						APIVersion:  version,
+						Rules:       &rules,
+						HTTPClient:  &http.Client{Transport: transport},
+						MaxBodySize: -1,
+					}
+					req := SearchRequest{Limit: len(users)}
+					b.SetBytes(int64(len(transport.body)))
+					b.ReportAllocs()
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						count := 0
+						var err error
+						if mode == "find" {
+							var result *SearchResponse
+							if result, err = client.FindUsers(req); err == nil {
+								count = len(result.Users)
+							}
+						} else {
+							_, err = client.FindUsersEach(context.Background(), req, func(User) error {
+								count++
+								return nil
+							})
+						}
+						if err != nil || count != len(users) {
+							b.Fatalf("find failed - %v", err)
+						}
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package lesson4
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen - выключатель разомкнут, запрос во внешнюю систему не отправлялся
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type BreakerState int
+
+const (
+	// запросы идут во внешнюю систему, неудачи подряд считаются
+	BreakerClosed BreakerState = iota
+	// запросы сразу получают ErrCircuitOpen, пока не пройдёт Cooldown
+	BreakerOpen
+	// пропускается один пробный запрос, по его результату выключатель замыкается или снова размыкается
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreaker перестаёт ходить во внешнюю систему, пока она лежит, чтобы запросы
+// не ждали таймаута один за другим. Неудача - ошибка сети, таймаут или 5xx, в том числе
+// оборванное посреди тело ответа; ответы 4xx
+// значат, что система жива, и считаются успехом. Поля настраиваются до первого запроса
+type CircuitBreaker struct {
+	// сколько неудач подряд размыкают выключатель
+	FailureThreshold int
+	// сколько ждать в разомкнутом состоянии перед пробным запросом
+	Cooldown time.Duration
+	// сколько пробных запросов подряд должны пройти, чтобы выключатель замкнулся, 0 - один
+	SuccessThreshold int
+	// вызывается при каждой смене состояния, после того как она произошла
+	OnStateChange func(from, to BreakerState)
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	successes int
+	openedAt  time.Time
+	// пробный запрос уже отправлен, остальные ждут его результата с ErrCircuitOpen
+	probing bool
+	// часы, подменяются в тестах
+	now func() time.Time
+}
+
+func NewCircuitBreaker(failureThreshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{FailureThreshold: failureThreshold, Cooldown: cooldown}
+}
+
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Allow решает, можно ли отправить запрос. После nil обязательно вызвать Record с его результатом
+// или Release, если результат ничего не говорит о внешней системе
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	from := b.state
+	if b.state == BreakerOpen && b.clock().Sub(b.openedAt) >= b.Cooldown {
+		b.setState(BreakerHalfOpen)
+	}
+	allowed := true
+	switch b.state {
+	case BreakerOpen:
+		allowed = false
+	case BreakerHalfOpen:
+		allowed = !b.probing
+		b.probing = true
+	}
+	to := b.state
+	b.mu.Unlock()
+
+	b.notify(from, to)
+	if !allowed {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// Record учитывает результат запроса, пропущенного Allow
+func (b *CircuitBreaker) Record(failed bool) {
+	b.mu.Lock()
+	from := b.state
+	switch b.state {
+	case BreakerClosed:
+		if !failed {
+			b.failures = 0
+			break
+		}
+		b.failures++
+		if b.failures >= b.FailureThreshold {
+			b.setState(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		b.probing = false
+		if failed {
+			b.setState(BreakerOpen)
+			break
+		}
+		b.successes++
+		if b.successes >= b.SuccessThreshold {
+			b.setState(BreakerClosed)
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+
+	b.notify(from, to)
+}
+
+// Release отпускает запрос, пропущенный Allow, не учитывая его результат: например, его отменил
+// сам вызывающий. Счётчики не меняются, а в полуоткрытом состоянии можно отправить новый пробный запрос
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+	}
+	b.mu.Unlock()
+}
+
+// setState меняет состояние и сбрасывает счётчики, вызывается под mu
+func (b *CircuitBreaker) setState(state BreakerState) {
+	b.state = state
+	b.failures = 0
+	b.successes = 0
+	b.probing = false
+	if state == BreakerOpen {
+		b.openedAt = b.clock()
+	}
+}
+
+// notify зовёт OnStateChange вне mu, чтобы из него можно было спросить State
+func (b *CircuitBreaker) notify(from, to BreakerState) {
+	if from != to && b.OnStateChange != nil {
+		b.OnStateChange(from, to)
+	}
+}
+
+func (b *CircuitBreaker) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
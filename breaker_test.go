@@ -0,0 +1,221 @@
+package lesson4
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"lesson4/fakesearch"
+)
+
+// fakeClock - часы выключателя, которые двигает тест
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func TestCircuitBreakerStates(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	breaker := NewCircuitBreaker(2, time.Second)
+	breaker.SuccessThreshold = 2
+	breaker.now = clock.Now
+	changes := []string{}
+	breaker.OnStateChange = func(from, to BreakerState) {
+		changes = append(changes, from.String()+"->"+to.String())
+	}
+
+	breaker.Allow()
+	breaker.Record(true)
+	breaker.Allow()
+	breaker.Record(false)
+	breaker.Allow()
+	breaker.Record(true)
+	if breaker.State() != BreakerClosed {
+		t.Fatalf("test failed - success must reset failures, state %s", breaker.State())
+	}
+	breaker.Allow()
+	breaker.Record(true)
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("test failed - 2 failures in a row must open, state %s", breaker.State())
+	}
+
+	if err := breaker.Allow(); err != ErrCircuitOpen {
+		t.Errorf("test failed - open breaker must reject, got %v", err)
+	}
+	clock.now = clock.now.Add(time.Second)
+	if err := breaker.Allow(); err != nil {
+		t.Errorf("test failed - probe after cooldown must pass, got %v", err)
+	}
+	if err := breaker.Allow(); err != ErrCircuitOpen {
+		t.Errorf("test failed - only one probe at a time, got %v", err)
+	}
+	breaker.Record(true)
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("test failed - failed probe must open again, state %s", breaker.State())
+	}
+
+	clock.now = clock.now.Add(time.Second)
+	breaker.Allow()
+	breaker.Record(false)
+	if breaker.State() != BreakerHalfOpen {
+		t.Fatalf("test failed - one probe of 2 must keep half-open, state %s", breaker.State())
+	}
+	breaker.Allow()
+	breaker.Record(false)
+	if breaker.State() != BreakerClosed {
+		t.Fatalf("test failed - 2 probes must close, state %s", breaker.State())
+	}
+
+	expected := []string{
+		"closed->open",
+		"open->half-open", "half-open->open",
+		"open->half-open", "half-open->closed",
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("test failed - expected changes %v, got %v", expected, changes)
+	}
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	var hits int32
+	failing := int32(1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			writeSearchError(w, r, errUnavailable)
+			return
+		}
+		SearchServer(w, r)
+	}))
+	defer ts.Close()
+
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	breaker := NewCircuitBreaker(3, 10*time.Second)
+	breaker.now = clock.Now
+	client := &SearchClient{AccessToken: "TestToken", URL: ts.URL, Breaker: breaker}
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.FindUsers(SearchRequest{Limit: 1}); !errors.Is(err, ErrFatal) {
+			t.Fatalf("test failed - expected fatal error, got %v", err)
+		}
+	}
+	_, err := client.FindUsers(SearchRequest{Limit: 1})
+	if err != ErrCircuitOpen {
+		t.Errorf("test failed - expected ErrCircuitOpen, got %v", err)
+	}
+	if _, err = client.GetUser(1); err != ErrCircuitOpen {
+		t.Errorf("test failed - GetUser must be rejected too, got %v", err)
+	}
+	if atomic.LoadInt32(&hits) != 3 {
+		t.Errorf("test failed - open breaker must not send requests, server got %d", hits)
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	clock.now = clock.now.Add(10 * time.Second)
+	if _, err = client.FindUsers(SearchRequest{Limit: 1}); err != nil {
+		t.Errorf("test failed - probe must pass, got %v", err)
+	}
+	if breaker.State() != BreakerClosed {
+		t.Errorf("test failed - breaker must close after probe, state %s", breaker.State())
+	}
+}
+
+func TestClientCircuitBreakerClientErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+
+	breaker := NewCircuitBreaker(1, time.Minute)
+	client := &SearchClient{AccessToken: "WrongToken", URL: ts.URL, Breaker: breaker}
+	for i := 0; i < 3; i++ {
+		if _, err := client.FindUsers(SearchRequest{Limit: 1}); !errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("test failed - expected unauthorized, got %v", err)
+		}
+	}
+	if breaker.State() != BreakerClosed {
+		t.Errorf("test failed - 4xx must not open breaker, state %s", breaker.State())
+	}
+}
+
+// TestClientCircuitBreakerCallerCancel - отмена и дедлайн вызывающего не считаются неудачей внешней системы,
+// а таймаут самого клиента считается
+func TestClientCircuitBreakerCallerCancel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer ts.Close()
+
+	breaker := NewCircuitBreaker(2, time.Minute)
+	client := &SearchClient{AccessToken: "TestToken", URL: ts.URL, Breaker: breaker}
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		if _, err := client.FindUsersContext(ctx, SearchRequest{Limit: 1}); !errors.Is(err, context.Canceled) {
+			t.Fatalf("test failed - expected canceled, got %v", err)
+		}
+
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := client.FindUsersContext(ctx, SearchRequest{Limit: 1})
+		cancel()
+		if !errors.Is(err, ErrTimeout) {
+			t.Fatalf("test failed - expected timeout, got %v", err)
+		}
+	}
+	if breaker.State() != BreakerClosed {
+		t.Fatalf("test failed - caller cancellation must not open breaker, state %s", breaker.State())
+	}
+
+	client.HTTPClient = &http.Client{Timeout: 10 * time.Millisecond}
+	for i := 0; i < 2; i++ {
+		if _, err := client.FindUsers(SearchRequest{Limit: 1}); !errors.Is(err, ErrTimeout) {
+			t.Fatalf("test failed - expected timeout, got %v", err)
+		}
+	}
+	if breaker.State() != BreakerOpen {
+		t.Errorf("test failed - client timeouts must open breaker, state %s", breaker.State())
+	}
+}
+
+// TestClientCircuitBreakerPartialBody - ответ 200, оборванный посреди тела, - неудача, а не успех
+func TestClientCircuitBreakerPartialBody(t *testing.T) {
+	ts := fakesearch.New(t)
+	ts.Default().Users(User{Id: 1, Name: "Mayer Hilda"}, User{Id: 2, Name: "Wolf Boyd"}).PartialBody(5)
+
+	breaker := NewCircuitBreaker(2, 0)
+	client := &SearchClient{AccessToken: "TestToken", URL: ts.URL, Breaker: breaker}
+	for i := 0; i < 2; i++ {
+		_, err := client.FindUsers(SearchRequest{Limit: 1})
+		tErr := &TransportError{}
+		if !errors.As(err, &tErr) || !tErr.Read {
+			t.Fatalf("test failed - expected read error, got %v", err)
+		}
+	}
+	if breaker.State() != BreakerOpen {
+		t.Errorf("test failed - partial bodies must open breaker, state %s", breaker.State())
+	}
+}
+
+func TestCircuitBreakerReleaseProbe(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	breaker := NewCircuitBreaker(1, time.Second)
+	breaker.now = clock.Now
+	breaker.Allow()
+	breaker.Record(true)
+	clock.now = clock.now.Add(time.Second)
+
+	if err := breaker.Allow(); err != nil {
+		t.Fatalf("test failed - probe must be allowed, got %v", err)
+	}
+	breaker.Release()
+	if err := breaker.Allow(); err != nil || breaker.State() != BreakerHalfOpen {
+		t.Errorf("test failed - released probe must let next probe through, got %v, state %s", err, breaker.State())
+	}
+}
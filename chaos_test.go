@@ -0,0 +1,110 @@
+package lesson4
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"lesson4/faultinject"
+)
+
+// TestChaosSoak гоняет FindUsers через портящий транспорт и проверяет, что каждая неисправность
+// превращается в свою ошибку, а не в панику, зависание или обрезанный результат.
+// Повторов в клиенте нет, поэтому каждая испорченная попытка видна вызывающему как ошибка
+func TestChaosSoak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("soak test")
+	}
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	chaos := faultinject.New(faultinject.Config{
+		Seed:                 2024,
+		LatencyRate:          0.2,
+		Latency:              30 * time.Millisecond,
+		ResetRate:            0.05,
+		ServerErrorRate:      0.05,
+		TruncateRate:         0.05,
+		WrongContentTypeRate: 0.05,
+		//даже первый кусок тела приходит позже таймаута клиента
+		SlowBodyRate:  0.05,
+		SlowBodyDelay: 400 * time.Millisecond,
+		SlowBodyChunk: 64,
+	}, nil)
+	metrics := NewClientMetrics()
+	client := &SearchClient{
+		AccessToken: "TestToken",
+		URL:         ts.URL,
+		APIVersion:  APIVersionV1,
+		Metrics:     metrics,
+		HTTPClient:  &http.Client{Transport: chaos, Timeout: 300 * time.Millisecond},
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	counts := map[string]int{}
+	for i := 0; i < 150; i++ {
+		req := SearchRequest{
+			Limit:      1 + rng.Intn(30),
+			Offset:     rng.Intn(40),
+			Query:      []string{"", "a", "Hilda"}[rng.Intn(3)],
+			OrderField: []string{"", "Id", "Age", "Name"}[rng.Intn(4)],
+			OrderBy:    rng.Intn(3) - 1,
+		}
+		result, err := client.FindUsers(req)
+
+		var searchErr *SearchError
+		var transportErr *TransportError
+		var decodeErr *DecodeError
+		if err != nil && RequestIDFromError(err) == "" {
+			t.Errorf("test failed - error without request id for %+v: %v", req, err)
+		}
+		switch {
+		case err == nil:
+			counts["ok"]++
+			if result == nil || len(result.Users) > req.Limit || len(result.Users) > DefaultValidationRules.MaxLimit {
+				t.Errorf("test failed - bad result for %+v: %v", req, result)
+			}
+		case result != nil:
+			t.Errorf("test failed - result %v together with error %v", result, err)
+		case errors.Is(err, ErrTimeout):
+			counts["timeout"]++
+		case errors.As(err, &searchErr) && searchErr.StatusCode >= http.StatusInternalServerError:
+			counts["server error"]++
+		case errors.Is(err, faultinject.ErrInjectedReset) && errors.As(err, &transportErr) && !transportErr.Read:
+			counts["reset"]++
+		case errors.As(err, &transportErr) && transportErr.Read:
+			counts["truncated"]++
+		case errors.As(err, &decodeErr) && decodeErr.Body == "result":
+			counts["wrong content type"]++
+		default:
+			t.Errorf("test failed - unexpected error for %+v: %v", req, err)
+		}
+	}
+
+	stats := chaos.Stats()
+	expected := map[string]int{
+		"timeout":            stats.SlowBody,
+		"server error":       stats.ServerErrors,
+		"reset":              stats.Resets,
+		"truncated":          stats.Truncated,
+		"wrong content type": stats.WrongContentType,
+		"ok":                 stats.Requests - stats.SlowBody - stats.ServerErrors - stats.Resets - stats.Truncated - stats.WrongContentType,
+	}
+	for class, count := range expected {
+		if count == 0 {
+			t.Errorf("test failed - seed gave no %s, pick rates so every fault happens", class)
+		}
+		if counts[class] != count {
+			t.Errorf("test failed - %s: expected %d, got %d", class, count, counts[class])
+		}
+	}
+	if metrics.InFlight.Get() != 0 {
+		t.Errorf("test failed - %d requests left in flight", metrics.InFlight.Get())
+	}
+	if metrics.Requests.Get(outcomeTimeout) != uint64(stats.SlowBody) {
+		t.Errorf("test failed - timeouts in metrics %d, injected %d", metrics.Requests.Get(outcomeTimeout), stats.SlowBody)
+	}
+}
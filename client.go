@@ -1,15 +1,23 @@
-package main
+// Package lesson4 - клиент внешней системы поиска пользователей SearchClient.
+// Консольный клиент поверх него - cmd/usersearch
+package lesson4
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
 	"time"
+
+	"lesson4/schema"
 )
 
 const (
@@ -22,21 +30,144 @@ var (
 	client  = &http.Client{Timeout: time.Second}
 )
 
-type User struct {
-	Id     int
-	Name   string
-	Age    int
-	About  string
-	Gender string
+// типы, которые ходят по сети, живут в пакете schema
+type (
+	User                = schema.User
+	SearchRequest       = schema.SearchRequest
+	SearchResponse      = schema.SearchResponse
+	SearchErrorResponse = schema.SearchErrorResponse
+	SearchBatchResult   = schema.SearchBatchResult
+	FieldError          = schema.FieldError
+)
+
+// классы ошибок внешней системы, *SearchError можно проверить на них через errors.Is
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrBadRequest   = errors.New("bad request")
+	ErrFatal        = errors.New("fatal error")
+	// внешняя система не ответила за отведённое время
+	ErrTimeout = errors.New("timeout")
+)
+
+// TimeoutError - ошибка таймаута, errors.Is(err, ErrTimeout) для неё истинно
+type TimeoutError struct {
+	// что запрашивали: параметры поиска без текста запроса или адрес
+	Target    string
+	RequestID string
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timeout for %s", e.Target)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return ErrTimeout
+}
+
+// TransportError - запрос ушёл, но ответа не получили или не дочитали: соединение сброшено, сервер недоступен
+type TransportError struct {
+	// Read - ошибка случилась, когда читали тело ответа
+	Read      bool
+	Err       error
+	RequestID string
+}
+
+func (e *TransportError) Error() string {
+	if e.Read {
+		return fmt.Sprintf("cant read response body: %s", e.Err)
+	}
+	return fmt.Sprintf("unknown error %s", e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeError - ответ внешней системы пришёл целиком, но не разобрался
+type DecodeError struct {
+	// что разбирали: "result", "error" или "batch"
+	Body      string
+	Err       error
+	RequestID string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("cant unpack %s json: %s", e.Body, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// SearchError - ошибка, которую вернула внешняя система
+type SearchError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Field      string
+	Errors     []FieldError
+	RequestID  string
+
+	text string
+}
+
+func (e *SearchError) Error() string {
+	return e.text
+}
+
+// Unwrap отдаёт класс ошибки по статусу ответа
+func (e *SearchError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case e.StatusCode >= 500:
+		return ErrFatal
+	}
+	return ErrBadRequest
+}
+
+func newSearchError(statusCode int, errResp SearchErrorResponse, req SearchRequest) *SearchError {
+	e := &SearchError{
+		StatusCode: statusCode,
+		Code:       errResp.Error,
+		Message:    errResp.Message,
+		Field:      errResp.Field,
+		Errors:     errResp.Errors,
+		RequestID:  errResp.RequestID,
+	}
+	switch {
+	case statusCode == http.StatusUnauthorized:
+		e.text = "Bad AccessToken"
+	case statusCode == http.StatusInternalServerError:
+		e.text = "SearchServer fatal error"
+	case statusCode == http.StatusNotAcceptable:
+		e.text = "SearchServer does not support requested format"
+	case errResp.Error == schema.CodeBadOrderField:
+		e.text = fmt.Sprintf("OrderFeld %s invalid", req.OrderField)
+	case errResp.Message == "" && statusCode == http.StatusBadRequest:
+		e.text = fmt.Sprintf("unknown bad request error: %s", errResp.Error)
+	case errResp.Message == "":
+		e.text = fmt.Sprintf("SearchServer error %d %s", statusCode, errResp.Error)
+	default:
+		e.text = fmt.Sprintf("SearchServer error %d %s: %s", statusCode, errResp.Error, errResp.Message)
+	}
+	return e
+}
+
+// UserNotFoundError - внешняя система не знает пользователей с такими Id
+type UserNotFoundError struct {
+	Ids       []int
+	RequestID string
 }
 
-type SearchResponse struct {
-	Users    []User
-	NextPage bool
+func (e *UserNotFoundError) Error() string {
+	return fmt.Sprintf("users not found: %v", e.Ids)
 }
 
-type SearchErrorResponse struct {
-	Error string
+// BatchResult - результат одного запроса из пачки. Заполнено либо Response, либо Err
+type BatchResult struct {
+	Response *SearchResponse
+	Err      error
 }
 
 const (
@@ -47,89 +178,442 @@ const (
 	ErrorBadOrderField = `OrderField invalid`
 )
 
-type SearchRequest struct {
-	Limit      int
-	Offset     int    // Можно учесть после сортировки
-	Query      string // подстрока в 1 из полей
-	OrderField string
-	// -1 по убыванию, 0 как встретилось, 1 по возрастанию
-	OrderBy int
-}
+// версии API внешней системы, пустая версия - старый путь без версии
+const (
+	APIVersionV1 = "v1"
+	APIVersionV2 = "v2"
+)
 
 type SearchClient struct {
 	// токен, по которому происходит авторизация на внешней системе, уходит туда через хедер
 	AccessToken string
 	// урл внешней системы, куда идти
 	URL string
+	// отправлять параметры поиска POST-ом в json-теле, а не в query string. Нужно для длинных запросов
+	UseJSONBody bool
+	// версия API: APIVersionV1, APIVersionV2 или пустая строка для старого пути без версии.
+	// v2 отдаёт готовый SearchResponse, и лишнюю запись для NextPage запрашивать не нужно
+	APIVersion string
+	// правила проверки запросов, nil - DefaultValidationRules. Слишком большой Limit урезается до MaxLimit
+	Rules *ValidationRules
+	// метрики запросов FindUsers, nil - не собирать
+	Metrics *ClientMetrics
+	// трейсер для спанов FindUsers, nil - не трассировать. Контекст трассы уходит во внешнюю систему в traceparent
+	Tracer *Tracer
+	// логгер для записей о FindUsers, nil - не писать. AccessToken в лог не попадает никогда
+	Logger *slog.Logger
+	// писать в лог хеш текста поиска вместо самого текста
+	HashQuery bool
+	// выключатель, который перестаёт ходить в лежащую внешнюю систему, nil - ходить всегда.
+	// Пока он разомкнут, все методы сразу возвращают ErrCircuitOpen
+	Breaker *CircuitBreaker
+	// ограничение на число одновременных FindUsers, nil - без ограничения
+	Limiter *ConcurrencyLimiter
+	// реплики внешней системы. Если заданы, запросы идут в них, а URL можно оставить пустым
+	Endpoints *EndpointPool
+	// http-клиент для походов во внешнюю систему, nil - общий клиент с таймаутом в секунду.
+	// Через него подключаются свои транспорты, например запись и воспроизведение ответов
+	HTTPClient *http.Client
+	// сколько байт ответа читать, 0 - DefaultMaxBodySize, отрицательное - без ограничения.
+	// Ответ длиннее - *BodyTooLargeError
+	MaxBodySize int64
 }
 
 // FindUsers отправляет запрос во внешнюю систему, которая непосредственно ищет пользоваталей
 func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
+	return srv.FindUsersContext(context.Background(), req)
+}
 
-	searcherParams := url.Values{}
-
-	if req.Limit < 0 {
-		return nil, fmt.Errorf("limit must be > 0")
+// FindUsersContext - FindUsers с контекстом. Отмена ctx прерывает запрос, id из ContextWithRequestID
+// уходит во внешнюю систему в X-Request-ID, без него id генерируется. Ошибки отдают его через RequestIDFromError
+func (srv *SearchClient) FindUsersContext(ctx context.Context, req SearchRequest) (*SearchResponse, error) {
+	result := &SearchResponse{Users: []User{}}
+	nextPage, err := srv.FindUsersEach(ctx, req, func(user User) error {
+		result.Users = append(result.Users, user)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	if req.Limit > 25 {
-		req.Limit = 25
+	result.NextPage = nextPage
+	return result, nil
+}
+
+// findUsers ходит во внешнюю систему и разбирает ответ потоком, отдавая пользователей в fn
+func (srv *SearchClient) findUsers(ctx context.Context, req SearchRequest, fn func(User) error) (bool, error) {
+	req, err := srv.prepareRequest(req)
+	if err != nil {
+		return false, err
 	}
-	if req.Offset < 0 {
-		return nil, fmt.Errorf("offset must be > 0")
+	if srv.APIVersion != APIVersionV2 {
+		//нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет
+		req.Limit++
 	}
 
-	//нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет
-	req.Limit++
-
+	searcherParams := url.Values{}
 	searcherParams.Add("limit", strconv.Itoa(req.Limit))
 	searcherParams.Add("offset", strconv.Itoa(req.Offset))
 	searcherParams.Add("query", req.Query)
 	searcherParams.Add("order_field", req.OrderField)
 	searcherParams.Add("order_by", strconv.Itoa(req.OrderBy))
 
-	searcherReq, err := http.NewRequest("GET", srv.URL+"?"+searcherParams.Encode(), nil)
-	searcherReq.Header.Add("AccessToken", srv.AccessToken)
+	var searcherReq *http.Request
+	if srv.UseJSONBody {
+		reqBody, err := json.Marshal(req)
+		if err != nil {
+			return false, fmt.Errorf("cant pack request json: %s", err)
+		}
+		searcherReq, err = srv.newRequest(ctx, "POST", srv.searchURL(), bytes.NewReader(reqBody))
+		if err != nil {
+			return false, fmt.Errorf("unknown error %s", err)
+		}
+		searcherReq.Header.Set("Content-Type", "application/json")
+	} else {
+		searcherReq, err = srv.newRequest(ctx, "GET", srv.searchURL()+"?"+searcherParams.Encode(), nil)
+		if err != nil {
+			return false, fmt.Errorf("unknown error %s", err)
+		}
+	}
+	if srv.APIVersion == APIVersionV2 {
+		searcherReq.Header.Set("Accept", schema.MediaTypeV2)
+	} else if srv.APIVersion != "" {
+		searcherReq.Header.Set("Accept", schema.MediaTypeJSON)
+	}
+
+	//текст поиска в ошибки не кладём, они могут попасть в логи
+	searcherParams.Del("query")
+	target := searcherParams.Encode()
 
-	resp, err := client.Do(searcherReq)
+	resp, err := srv.do(searcherReq)
 	if err != nil {
+		if errors.Is(err, ErrCircuitOpen) {
+			return false, err
+		}
 		if err, ok := err.(net.Error); ok && err.Timeout() {
-			return nil, fmt.Errorf("timeout for %s", searcherParams.Encode())
+			return false, &TimeoutError{Target: target}
 		}
-		return nil, fmt.Errorf("unknown error %s", err)
+		return false, &TransportError{Err: err}
 	}
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-
-	switch resp.StatusCode {
-	case http.StatusUnauthorized:
-		return nil, fmt.Errorf("Bad AccessToken")
-	case http.StatusInternalServerError:
-		return nil, fmt.Errorf("SearchServer fatal error")
-	case http.StatusBadRequest:
-		errResp := SearchErrorResponse{}
-		err = json.Unmarshal(body, &errResp)
+	body := srv.newBodyReader(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		errBody, err := body.readAll(target)
 		if err != nil {
-			return nil, fmt.Errorf("cant unpack error json: %s", err)
+			return false, err
 		}
-		if errResp.Error == "ErrorBadOrderField" {
-			return nil, fmt.Errorf("OrderFeld %s invalid", req.OrderField)
+		return false, checkStatus(resp.StatusCode, errBody, req)
+	}
+
+	nextPage, err := decodeStream(body, srv.APIVersion == APIVersionV2, req, fn)
+	//оборванное или слишком длинное тело важнее того, что json из него не разобрался
+	if readErr := body.check(target); readErr != nil {
+		return false, readErr
+	}
+	return nextPage, err
+}
+
+// FindUsersBatch отправляет несколько запросов одним походом во внешнюю систему.
+// Результаты идут в том же порядке, что и запросы. Ошибка отдельного запроса лежит в его BatchResult,
+// общая ошибка возвращается, только если не удалось выполнить всю пачку
+func (srv *SearchClient) FindUsersBatch(reqs []SearchRequest) ([]BatchResult, error) {
+	results := make([]BatchResult, len(reqs))
+
+	//невалидные запросы во внешнюю систему не отправляем
+	toSend := make([]SearchRequest, 0, len(reqs))
+	sentIdx := make([]int, 0, len(reqs))
+	for i, req := range reqs {
+		prepared, err := srv.prepareRequest(req)
+		if err != nil {
+			results[i].Err = err
+			continue
 		}
-		return nil, fmt.Errorf("unknown bad request error: %s", errResp.Error)
+		//элементы пачки всегда в формате v1, поэтому лишняя запись для NextPage нужна и здесь
+		prepared.Limit++
+		toSend = append(toSend, prepared)
+		sentIdx = append(sentIdx, i)
+	}
+	if len(toSend) == 0 {
+		return results, nil
 	}
 
-	data := []User{}
+	reqBody, err := json.Marshal(toSend)
+	if err != nil {
+		return nil, fmt.Errorf("cant pack batch json: %s", err)
+	}
+
+	ctx, requestID := ensureRequestID(context.Background())
+	searcherReq, err := srv.newRequest(ctx, "POST", srv.endpoint("/users/batch"), bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("unknown error %s", err)
+	}
+	searcherReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := srv.do(searcherReq)
+	if err != nil {
+		if errors.Is(err, ErrCircuitOpen) {
+			return nil, err
+		}
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			return nil, &TimeoutError{Target: fmt.Sprintf("batch of %d requests", len(toSend)), RequestID: requestID}
+		}
+		return nil, &TransportError{Err: err, RequestID: requestID}
+	}
+	defer resp.Body.Close()
+	body, err := srv.newBodyReader(resp.Body).readAll(fmt.Sprintf("batch of %d requests", len(toSend)))
+	if err != nil {
+		return nil, withRequestID(err, requestID)
+	}
+
+	if err = checkStatus(resp.StatusCode, body, SearchRequest{}); err != nil {
+		return nil, withRequestID(err, requestID)
+	}
+
+	data := []SearchBatchResult{}
 	err = json.Unmarshal(body, &data)
 	if err != nil {
-		return nil, fmt.Errorf("cant unpack result json: %s", err)
+		return nil, &DecodeError{Body: "batch", Err: err, RequestID: requestID}
+	}
+	if len(data) != len(toSend) {
+		err = fmt.Errorf("batch size mismatch: sent %d, got %d", len(toSend), len(data))
+		return nil, &DecodeError{Body: "batch", Err: err, RequestID: requestID}
 	}
 
-	result := SearchResponse{}
-	if len(data) == req.Limit {
-		result.NextPage = true
-		result.Users = data[0 : len(data)-1]
-	} else {
-		result.Users = data[0:len(data)]
+	for i, item := range data {
+		response, err := decodeResponse(item.StatusCode, item.Body, toSend[i])
+		results[sentIdx[i]].Response, results[sentIdx[i]].Err = response, withRequestID(err, requestID)
+	}
+	return results, nil
+}
+
+// GetUser возвращает пользователя по Id. Если такого нет - ошибка *UserNotFoundError
+func (srv *SearchClient) GetUser(id int) (*User, error) {
+	user := &User{}
+	ctx, _ := ensureRequestID(context.Background())
+	err := srv.getUsers(ctx, srv.endpoint("/users/"+strconv.Itoa(id)), user)
+	//404 без кода пользователя - не та ручка или прокси, а не отсутствие пользователя
+	if sErr, ok := err.(*SearchError); ok && sErr.StatusCode == http.StatusNotFound && sErr.Code == schema.CodeUserNotFound {
+		return nil, &UserNotFoundError{Ids: []int{id}, RequestID: sErr.RequestID}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetUsers возвращает пользователей по списку Id в том же порядке за один поход во внешнюю систему.
+// Если кого-то не нашлось, вместе с найденными возвращается *UserNotFoundError со всеми пропавшими Id
+func (srv *SearchClient) GetUsers(ids ...int) ([]User, error) {
+	if len(ids) == 0 {
+		return []User{}, nil
+	}
+
+	searcherParams := url.Values{}
+	for _, id := range ids {
+		searcherParams.Add("id", strconv.Itoa(id))
+	}
+	data := []User{}
+	ctx, requestID := ensureRequestID(context.Background())
+	if err := srv.getUsers(ctx, srv.endpoint("/users?"+searcherParams.Encode()), &data); err != nil {
+		return nil, err
+	}
+
+	found := make(map[int]bool, len(data))
+	for _, user := range data {
+		found[user.Id] = true
+	}
+	missing := []int{}
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		return data, &UserNotFoundError{Ids: missing, RequestID: requestID}
+	}
+	return data, nil
+}
+
+// getUsers делает GET во внешнюю систему с id запроса из ctx и разбирает тело успешного ответа в result
+func (srv *SearchClient) getUsers(ctx context.Context, reqURL string, result interface{}) error {
+	requestID := RequestIDFromContext(ctx)
+	searcherReq, err := srv.newRequest(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("unknown error %s", err)
+	}
+
+	resp, err := srv.do(searcherReq)
+	if err != nil {
+		if errors.Is(err, ErrCircuitOpen) {
+			return err
+		}
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			return &TimeoutError{Target: reqURL, RequestID: requestID}
+		}
+		return &TransportError{Err: err, RequestID: requestID}
+	}
+	defer resp.Body.Close()
+	body, err := srv.newBodyReader(resp.Body).readAll(reqURL)
+	if err != nil {
+		return withRequestID(err, requestID)
 	}
 
-	return &result, err
+	if err = checkStatus(resp.StatusCode, body, SearchRequest{}); err != nil {
+		return withRequestID(err, requestID)
+	}
+	if err = json.Unmarshal(body, result); err != nil {
+		return &DecodeError{Body: "result", Err: err, RequestID: requestID}
+	}
+	return nil
+}
+
+// newRequest собирает запрос во внешнюю систему с авторизацией, id запроса и контекстом трассы из ctx
+func (srv *SearchClient) newRequest(ctx context.Context, method, reqURL string, body io.Reader) (*http.Request, error) {
+	searcherReq, err := http.NewRequestWithContext(ctx, method, reqURL, body)
+	if err != nil {
+		return nil, err
+	}
+	searcherReq.Header.Add("AccessToken", srv.AccessToken)
+	searcherReq.Header.Set(requestIDHeader, RequestIDFromContext(ctx))
+	InjectTraceParent(ctx, searcherReq.Header)
+	return searcherReq, nil
+}
+
+// do отправляет запрос во внешнюю систему через выключатель, если он задан
+func (srv *SearchClient) do(searcherReq *http.Request) (*http.Response, error) {
+	if srv.Breaker == nil {
+		return srv.send(searcherReq)
+	}
+	if err := srv.Breaker.Allow(); err != nil {
+		return nil, err
+	}
+	resp, err := srv.send(searcherReq)
+	if err != nil && searcherReq.Context().Err() != nil {
+		//запрос отменил или ограничил по времени сам вызывающий, внешняя система тут ни при чём.
+		//Таймаут http.Client контекст запроса не отменяет и считается неудачей
+		srv.Breaker.Release()
+		return resp, err
+	}
+	if err != nil {
+		srv.Breaker.Record(true)
+		return resp, err
+	}
+	//оборванное или зависшее посреди тело - такая же неудача, как до заголовков,
+	//поэтому результат учитывается, только когда тело дочитано или закрыто
+	resp.Body = &breakerBody{
+		ReadCloser: resp.Body,
+		breaker:    srv.Breaker,
+		ctx:        searcherReq.Context(),
+		failed:     resp.StatusCode >= http.StatusInternalServerError,
+	}
+	return resp, nil
+}
+
+// breakerBody учитывает в выключателе результат запроса один раз: на ошибке чтения тела,
+// на его конце или на Close, если тело не дочитали
+type breakerBody struct {
+	io.ReadCloser
+	breaker *CircuitBreaker
+	ctx     context.Context
+	failed  bool
+	once    sync.Once
+}
+
+func (b *breakerBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	switch {
+	case err == io.EOF:
+		b.once.Do(func() { b.breaker.Record(b.failed) })
+	case err != nil && b.ctx.Err() != nil:
+		//чтение оборвала отмена или дедлайн вызывающего
+		b.once.Do(b.breaker.Release)
+	case err != nil:
+		b.once.Do(func() { b.breaker.Record(true) })
+	}
+	return n, err
+}
+
+func (b *breakerBody) Close() error {
+	b.once.Do(func() { b.breaker.Record(b.failed) })
+	return b.ReadCloser.Close()
+}
+
+// send отправляет запрос по URL или в одну из реплик Endpoints
+func (srv *SearchClient) send(searcherReq *http.Request) (*http.Response, error) {
+	if srv.Endpoints == nil {
+		return srv.httpClient().Do(searcherReq)
+	}
+	return srv.Endpoints.do(srv.httpClient(), searcherReq, srv.URL)
+}
+
+func (srv *SearchClient) httpClient() *http.Client {
+	if srv.HTTPClient != nil {
+		return srv.HTTPClient
+	}
+	return client
+}
+
+// rules - правила проверки запросов клиента
+func (srv *SearchClient) rules() ValidationRules {
+	if srv.Rules == nil {
+		return DefaultValidationRules
+	}
+	return *srv.Rules
+}
+
+// prepareRequest проверяет запрос и готовит его к отправке
+func (srv *SearchClient) prepareRequest(req SearchRequest) (SearchRequest, error) {
+	rules := srv.rules()
+	req.Limit = rules.ClampLimit(req.Limit)
+	if err := rules.Validate(req); err != nil {
+		return req, err
+	}
+	return req, nil
+}
+
+// searchURL - адрес поиска с учётом версии API
+func (srv *SearchClient) searchURL() string {
+	if srv.APIVersion == "" {
+		return srv.URL
+	}
+	return srv.endpoint("/users/search")
+}
+
+// endpoint - адрес ручки внешней системы с учётом версии API
+func (srv *SearchClient) endpoint(path string) string {
+	if srv.APIVersion == "" {
+		return srv.URL + path
+	}
+	return srv.URL + "/" + srv.APIVersion + path
+}
+
+// readError - ошибка чтения тела ответа. Оборванное тело - ошибка, а не обрезанный результат,
+// а таймаут посреди тела - такой же таймаут, как до ответа
+func readError(err error, target string) error {
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		return &TimeoutError{Target: target}
+	}
+	return &TransportError{Read: true, Err: err}
+}
+
+// checkStatus превращает неуспешный ответ внешней системы в *SearchError.
+// Тело ответа с ошибкой - SearchErrorResponse, пустое тело тоже допустимо
+func checkStatus(statusCode int, body []byte, req SearchRequest) error {
+	if statusCode == http.StatusOK {
+		return nil
+	}
+
+	errResp := SearchErrorResponse{}
+	if len(body) > 0 {
+		err := json.Unmarshal(body, &errResp)
+		//без json в 400 не понять, какое поле не так - это ошибка протокола;
+		//остальные статусы с телом не в json обычно отдаёт прокси перед внешней системой, статуса достаточно
+		if err != nil && statusCode == http.StatusBadRequest {
+			return &DecodeError{Body: "error", Err: err}
+		}
+		if err != nil {
+			errResp = SearchErrorResponse{}
+		}
+	}
+	return newSearchError(statusCode, errResp, req)
 }
@@ -1,211 +1,635 @@
-package main
+package lesson4
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
-	"encoding/xml"
+	"errors"
 	"io/ioutil"
+	"log/slog"
+	"mime"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"net/url"
 	"reflect"
 	"sort"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
+
+	"lesson4/datagen"
+	"lesson4/fakesearch"
+	"lesson4/schema"
+)
+
+// searchParams - разобранные параметры поиска, общие для GET-запроса и пачки
+type searchParams struct {
+	limit      int
+	offset     int
+	query      string
+	orderField string
+	orderBy    int
+}
+
+// searchError - ошибка, которую сервер отдаёт клиенту: статус и поля SearchErrorResponse
+type searchError struct {
+	status  int
+	code    string
+	message string
+	field   string
+	errors  []FieldError
+}
+
+// validationError - 400 со списком ошибок по полям, код и сообщение берутся из первой
+func validationError(errs []FieldError) *searchError {
+	return &searchError{http.StatusBadRequest, errs[0].Code, errs[0].Message, errs[0].Field, errs}
+}
+
+// serverRules - правила, по которым сервер проверяет запросы
+var serverRules = DefaultValidationRules
+
+// wireRules - правила для v1 и пачки: там клиент запрашивает на одну запись больше,
+// чтобы узнать про следующую страницу, поэтому и лимит на одну запись больше
+func wireRules() ValidationRules {
+	rules := serverRules
+	if rules.MaxLimit > 0 {
+		rules.MaxLimit++
+	}
+	return rules
+}
+
+// общие ошибки всех ручек
+var (
+	errUnavailable      = &searchError{http.StatusServiceUnavailable, schema.CodeUnavailable, "dataset is unavailable", "", nil}
+	errBadAccessToken   = &searchError{http.StatusUnauthorized, schema.CodeBadAccessToken, "AccessToken is invalid", "AccessToken", nil}
+	errMethodNotAllowed = &searchError{http.StatusMethodNotAllowed, schema.CodeMethodNotAllowed, "method is not allowed", "", nil}
+	errNotAcceptable    = &searchError{http.StatusNotAcceptable, schema.CodeNotAcceptable, "no acceptable media type", "Accept", nil}
+)
+
+// errorResponse - тело ответа для ошибки sErr
+func errorResponse(r *http.Request, sErr *searchError) SearchErrorResponse {
+	return SearchErrorResponse{
+		Error:     sErr.code,
+		Message:   sErr.message,
+		Field:     sErr.field,
+		Errors:    sErr.errors,
+		RequestID: requestID(r),
+	}
+}
+
+func writeSearchError(w http.ResponseWriter, r *http.Request, sErr *searchError) {
+	jsonResult, _ := json.Marshal(errorResponse(r, sErr))
+	w.Header().Set("Content-Type", schema.MediaTypeJSON)
+	w.WriteHeader(sErr.status)
+	w.Write(jsonResult)
+}
+
+// requestID - идентификатор запроса для ответа с ошибкой.
+// Сервер генерирует его один раз на запрос и запоминает в заголовках, чтобы у всех ошибок пачки он был общий
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" {
+		return id
+	}
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	id := hex.EncodeToString(buf)
+	r.Header.Set("X-Request-ID", id)
+	return id
+}
+
+// searchMux собирает все ручки внешней системы.
+// Старые пути без версии оставлены для совместимости и отвечают так же, как /v1
+func searchMux() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", SearchServer)
+	mux.HandleFunc("/users/batch", SearchBatchServer)
+	mux.HandleFunc("/users/", UserServer)
+	mux.HandleFunc("/users", UsersServer)
+
+	mux.HandleFunc("/openapi.json", OpenAPIServer)
+
+	mux.HandleFunc("/v1/users/search", SearchServerV1)
+	mux.HandleFunc("/v2/users/search", SearchServerV2)
+	for _, version := range []string{"/v1", "/v2"} {
+		mux.HandleFunc(version+"/users/batch", SearchBatchServer)
+		mux.HandleFunc(version+"/users/", UserServer)
+		mux.HandleFunc(version+"/users", UsersServer)
+	}
+
+	mux.Handle("/metrics", serverMetrics)
+	return serverMetrics.Middleware(serverTracer.Middleware(echoRequestID(LogRequests(serverLogger, serverHashQuery, mux))))
+}
+
+// echoRequestID возвращает X-Request-ID запроса в ответе. Если клиент его не прислал, выдаётся новый,
+// и тот же id попадает в тело ошибки и в лог
+func echoRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-ID", requestID(r))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serverMetrics - метрики тестового сервера, отдаются на /metrics
+var serverMetrics = NewServerMetrics()
+
+// serverTracer - трейсер тестового сервера, nil - не трассировать
+var serverTracer *Tracer
+
+// логгер тестового сервера, nil - не писать. С serverHashQuery вместо текста поиска пишется его хеш
+var (
+	serverLogger    *slog.Logger
+	serverHashQuery bool
 )
 
 func SearchServer(w http.ResponseWriter, r *http.Request) {
-	//получение данных из xml. Если это у нас не выйдет, то сервис недоступен
-	file, err := os.Open("dataset.xml")
+	rows, params, ok := prepareSearch(w, r, wireRules())
+	if !ok {
+		return
+	}
+
+	users, sErr := searchUsers(r.Context(), rows, params)
+	if sErr != nil {
+		writeSearchError(w, r, sErr)
+		return
+	}
+
+	_, span := StartSpan(r.Context(), "encode")
+	jsonResult, err := json.Marshal(users)
+	span.Finish()
 	if err != nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
+		writeSearchError(w, r, errUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", schema.MediaTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResult)
+}
+
+// SearchServerV1 - тот же SearchServer, но с проверкой заголовка Accept
+func SearchServerV1(w http.ResponseWriter, r *http.Request) {
+	if negotiate(r, schema.MediaTypeJSON) == "" {
+		writeSearchError(w, r, errNotAcceptable)
 		return
 	}
-	fileInfo, err := ioutil.ReadAll(file)
+	SearchServer(w, r)
+}
+
+// SearchServerV2 отвечает конвертом SearchResponse: limit - это размер страницы,
+// а NextPage сервер считает сам, клиенту больше не нужно запрашивать лишнюю запись
+func SearchServerV2(w http.ResponseWriter, r *http.Request) {
+	mediaType := negotiate(r, schema.MediaTypeV2, schema.MediaTypeJSON)
+	if mediaType == "" {
+		writeSearchError(w, r, errNotAcceptable)
+		return
+	}
+
+	rows, params, ok := prepareSearch(w, r, serverRules)
+	if !ok {
+		return
+	}
+
+	limit := params.limit
+	if limit > 0 {
+		params.limit++
+	}
+	users, sErr := searchUsers(r.Context(), rows, params)
+	if sErr != nil {
+		writeSearchError(w, r, sErr)
+		return
+	}
+
+	result := SearchResponse{Users: users}
+	if limit > 0 && len(users) > limit {
+		result.NextPage = true
+		result.Users = users[:limit]
+	}
+
+	_, span := StartSpan(r.Context(), "encode")
+	jsonResult, err := json.Marshal(result)
+	span.Finish()
 	if err != nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
+		writeSearchError(w, r, errUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResult)
+}
+
+// OpenAPIServer отдаёт описание API из openapi.json, токен для него не нужен
+func OpenAPIServer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeSearchError(w, r, errMethodNotAllowed)
 		return
 	}
-	fileData := Root{}
-	xml.Unmarshal(fileInfo, &fileData)
+
+	spec, err := ioutil.ReadFile("openapi.json")
 	if err != nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
+		writeSearchError(w, r, errUnavailable)
 		return
 	}
-	rows := fileData.Rows
+
+	w.Header().Set("Content-Type", schema.MediaTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	w.Write(spec)
+}
+
+// prepareSearch - общая часть всех версий поиска: данные, авторизация и параметры.
+// Если ok == false, ответ с ошибкой уже записан
+func prepareSearch(w http.ResponseWriter, r *http.Request, rules ValidationRules) (rows []Row, params searchParams, ok bool) {
+	//получение данных из xml. Если это у нас не выйдет, то сервис недоступен
+	_, span := StartSpan(r.Context(), "load")
+	rows, err := loadRows()
+	span.SetError(err)
+	span.Finish()
+	if err != nil {
+		writeSearchError(w, r, errUnavailable)
+		return nil, params, false
+	}
 
 	//проверка авторизации
-	token := r.Header.Get("AccessToken")
-	if token != "TestToken" {
-		w.WriteHeader(http.StatusUnauthorized)
+	_, span = StartSpan(r.Context(), "auth")
+	authorized := checkToken(r)
+	span.SetAttribute("authorized", authorized)
+	span.Finish()
+	if !authorized {
+		writeSearchError(w, r, errBadAccessToken)
+		return nil, params, false
+	}
+
+	var sErr *searchError
+	switch r.Method {
+	case http.MethodGet:
+		params, sErr = parseSearchParams(r.URL.Query(), rules)
+	case http.MethodPost:
+		params, sErr = parseSearchBody(r, rules)
+	default:
+		writeSearchError(w, r, errMethodNotAllowed)
+		return nil, params, false
+	}
+	if sErr != nil {
+		writeSearchError(w, r, sErr)
+		return nil, params, false
+	}
+	serverMetrics.OrderFields.Inc(params.orderField)
+	return rows, params, true
+}
+
+// negotiate выбирает по заголовку Accept первый подходящий из supported медиа-типов.
+// Пустой Accept подходит ко всему, пустая строка в ответе - ничего не подошло
+func negotiate(r *http.Request, supported ...string) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return supported[0]
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		for _, s := range supported {
+			if mediaType == s || mediaType == "*/*" || mediaType == "application/*" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// SearchBatchServer принимает POST-ом массив SearchRequest и отвечает на каждый из них отдельно,
+// чтобы одна ошибка не валила всю пачку
+func SearchBatchServer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeSearchError(w, r, errMethodNotAllowed)
+		return
+	}
+
+	rows, err := loadRows()
+	if err != nil {
+		writeSearchError(w, r, errUnavailable)
+		return
+	}
+
+	if !checkToken(r) {
+		writeSearchError(w, r, errBadAccessToken)
+		return
+	}
+
+	requests := []SearchRequest{}
+	if err = json.NewDecoder(r.Body).Decode(&requests); err != nil {
+		writeSearchError(w, r, &searchError{http.StatusBadRequest, schema.CodeBadBody, "body must be a json array of SearchRequest", "body", nil})
+		return
+	}
+
+	results := make([]SearchBatchResult, 0, len(requests))
+	for _, req := range requests {
+		results = append(results, searchBatchItem(r, rows, req))
+	}
+
+	jsonResult, err := json.Marshal(results)
+	if err != nil {
+		writeSearchError(w, r, errUnavailable)
 		return
 	}
 
+	w.Header().Set("Content-Type", schema.MediaTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResult)
+}
+
+func searchBatchItem(r *http.Request, rows []Row, req SearchRequest) SearchBatchResult {
+	params := requestToParams(req)
+	sErr := checkSearchParams(&params, wireRules(), nil)
+	var users []User
+	if sErr == nil {
+		users, sErr = searchUsers(r.Context(), rows, params)
+	}
+	if sErr != nil {
+		body, _ := json.Marshal(errorResponse(r, sErr))
+		return SearchBatchResult{StatusCode: sErr.status, Body: body}
+	}
+
+	body, _ := json.Marshal(users)
+	return SearchBatchResult{StatusCode: http.StatusOK, Body: body}
+}
+
+// datasetPath - откуда внешняя система берёт пользователей. Формат - по расширению, см. datagen.Formats
+var datasetPath = "dataset.xml"
+
+func loadRows() ([]Row, error) {
+	return datagen.ReadFile(datasetPath)
+}
+
+func checkToken(r *http.Request) bool {
+	return r.Header.Get("AccessToken") == "TestToken"
+}
+
+func parseSearchParams(values url.Values, rules ValidationRules) (searchParams, *searchError) {
 	//поля из SearchRequest
-	limitStr := r.URL.Query().Get("limit")
-	offsetStr := r.URL.Query().Get("offset")
-	query := r.URL.Query().Get("query")
-	orderField := r.URL.Query().Get("order_field")
-	orderByStr := r.URL.Query().Get("order_by")
+	limitStr := values.Get("limit")
+	offsetStr := values.Get("offset")
+	orderByStr := values.Get("order_by")
 
-	// limit, offset, orderBy := 0, 0, 0
-	var (
-		limit   = 0
-		offset  = 0
-		orderBy = 0
-	)
+	params := searchParams{
+		query:      values.Get("query"),
+		orderField: values.Get("order_field"),
+	}
+	var err error
+	errs := []FieldError{}
 	//проверяем интовые значения
 	if limitStr != "" {
-		if limit, err = strconv.Atoi(limitStr); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Limit must be integer"))
-			return
+		if params.limit, err = strconv.Atoi(limitStr); err != nil {
+			errs = append(errs, FieldError{Field: "limit", Code: schema.CodeBadLimit, Message: "Limit must be integer"})
 		}
 	}
 	if offsetStr != "" {
-		if offset, err = strconv.Atoi(offsetStr); err != nil || offset < 0 {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Offset must be integer more than 0"))
-			return
+		if params.offset, err = strconv.Atoi(offsetStr); err != nil {
+			errs = append(errs, FieldError{Field: "offset", Code: schema.CodeBadOffset, Message: "Offset must be integer more than 0"})
 		}
 	}
 	if orderByStr != "" {
-		orderBy, err = strconv.Atoi(orderByStr)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("order_by must be -1, 0 or 1"))
-			return
-		}
-		if orderBy != -1 && orderBy != 0 && orderBy != 1 {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("order_by must be -1, 0 or 1"))
-			return
+		if params.orderBy, err = strconv.Atoi(orderByStr); err != nil {
+			errs = append(errs, FieldError{Field: "order_by", Code: schema.CodeBadOrderBy, Message: "order_by must be -1, 0 or 1"})
 		}
 	}
 
-	//проверка валидности поля orderField
-	//работает по полям `Id`, `Age`, `Name`
-	//дабы не путаться с регистрами сделаю все в нижнем
-	orderField = strings.ToLower(orderField)
-	x := orderField != "id"
-	x1 := orderField != "age"
-	x2 := orderField != "name"
-	if x && x1 && x2 && orderField != "" {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(`{"Error":"ErrorBadOrderField"}`))
-		return
+	return params, checkSearchParams(&params, rules, errs)
+}
+
+// parseSearchBody читает параметры из json-тела POST-запроса в формате SearchRequest
+func parseSearchBody(r *http.Request, rules ValidationRules) (searchParams, *searchError) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return searchParams{}, &searchError{http.StatusUnsupportedMediaType, schema.CodeUnsupportedMediaType, "Content-Type must be application/json", "Content-Type", nil}
+	}
+
+	req := SearchRequest{}
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return searchParams{}, &searchError{http.StatusBadRequest, schema.CodeBadBody, "body must be a json SearchRequest", "body", nil}
+	}
+
+	params := requestToParams(req)
+	return params, checkSearchParams(&params, rules, nil)
+}
+
+func requestToParams(req SearchRequest) searchParams {
+	return searchParams{
+		limit:      req.Limit,
+		offset:     req.Offset,
+		query:      req.Query,
+		orderField: req.OrderField,
+		orderBy:    req.OrderBy,
 	}
+}
+
+func paramsToRequest(params searchParams) SearchRequest {
+	return SearchRequest{
+		Limit:      params.limit,
+		Offset:     params.offset,
+		Query:      params.query,
+		OrderField: params.orderField,
+		OrderBy:    params.orderBy,
+	}
+}
+
+// checkSearchParams проверяет параметры по правилам rules и приводит order_field к нижнему регистру.
+// parseErrs - ошибки разбора, которые нашлись раньше, они попадают в тот же список
+func checkSearchParams(params *searchParams, rules ValidationRules, parseErrs []FieldError) *searchError {
+	errs := parseErrs
+	if err := rules.Validate(paramsToRequest(*params)); err != nil {
+		errs = append(errs, err.(*ValidationError).Errors...)
+	}
+	if len(errs) > 0 {
+		return validationError(errs)
+	}
+
+	//дабы не путаться с регистрами сделаю все в нижнем
+	params.orderField = strings.ToLower(params.orderField)
 	//если пустой - то возвращаем по `Name`
-	if orderField == "" {
-		orderField = "name"
+	if params.orderField == "" {
+		params.orderField = "name"
 	}
+	return nil
+}
 
+// searchUsers ищет, сортирует и отрезает страницу. Исходный слайс rows не меняется
+func searchUsers(ctx context.Context, rows []Row, params searchParams) ([]User, *searchError) {
 	//начинаем поиск по query
-	resultRows := make([]Row, 0, 0)
-	if query != "" {
-		for _, row := range rows {
-			name := row.LastName + " " + row.FirstName
-			if strings.Contains(name, query) || strings.Contains(row.About, query) {
-				resultRows = append(resultRows, row)
-			}
-		}
-		if len(resultRows) == 0 {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(`{}`))
-			return
+	_, span := StartSpan(ctx, "filter")
+	resultRows := make([]Row, 0, len(rows))
+	for _, row := range rows {
+		name := row.LastName + " " + row.FirstName
+		if params.query == "" || strings.Contains(name, params.query) || strings.Contains(row.About, params.query) {
+			resultRows = append(resultRows, row)
 		}
 	}
+	rows = resultRows
+	span.SetAttribute("rows", len(rows))
+	span.Finish()
 
 	//сортировки
-	if orderBy != 0 {
-		switch orderField {
+	_, span = StartSpan(ctx, "sort")
+	span.SetAttribute("order_field", params.orderField)
+	span.SetAttribute("order_by", params.orderBy)
+	if params.orderBy != 0 {
+		switch params.orderField {
 		case "name":
-			if orderBy == 1 {
+			if params.orderBy == 1 {
 				sort.Sort(ByName(rows))
 			}
-			if orderBy == -1 {
+			if params.orderBy == -1 {
 				sort.Sort(sort.Reverse(ByName(rows)))
 			}
 		case "id":
-			if orderBy == 1 {
+			if params.orderBy == 1 {
 				sort.Sort(ById(rows))
 			}
-			if orderBy == -1 {
+			if params.orderBy == -1 {
 				sort.Sort(sort.Reverse(ById(rows)))
 			}
 		case "age":
-			if orderBy == 1 {
+			if params.orderBy == 1 {
 				sort.Sort(ByAge(rows))
 			}
-			if orderBy == -1 {
+			if params.orderBy == -1 {
 				sort.Sort(sort.Reverse(ByAge(rows)))
 			}
 		}
 	}
+	span.Finish()
 
-	lastElement := offset + limit
-	if lastElement > 0 {
-		if len(rows) < offset {
-			rows = make([]Row, 0)
+	_, span = StartSpan(ctx, "paginate")
+	defer span.Finish()
+	//offset+limit не считаем: при огромном offset сумма переполняется и страница становится всем результатом
+	offset, limit := params.offset, params.limit
+	if offset > 0 || limit > 0 {
+		if offset > len(rows) {
+			offset = len(rows)
 		}
-		if len(rows) >= lastElement {
-			rows = rows[offset:lastElement]
-		} else if len(rows) >= offset {
-			rows = rows[offset:]
+		if limit > len(rows)-offset {
+			limit = len(rows) - offset
 		}
+		rows = rows[offset : offset+limit]
 	}
 	users := make([]User, 0, len(rows))
 	for _, row := range rows {
-		var user User
-		user.Id = row.Id
-		user.Name = row.LastName + " " + row.FirstName
-		user.About = row.About
-		user.Age = row.Age
-		user.Gender = row.Gender
-		users = append(users, user)
+		users = append(users, rowToUser(row))
 	}
+	return users, nil
+}
 
-	jsonResult, err := json.Marshal(users)
+func rowToUser(row Row) User {
+	var user User
+	user.Id = row.Id
+	user.Name = row.LastName + " " + row.FirstName
+	user.About = row.About
+	user.Age = row.Age
+	user.Gender = row.Gender
+	return user
+}
+
+// UserServer отдаёт одного пользователя по пути /users/{id}, для неизвестного id - 404
+func UserServer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeSearchError(w, r, errMethodNotAllowed)
+		return
+	}
+
+	rows, err := loadRows()
 	if err != nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
+		writeSearchError(w, r, errUnavailable)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write(jsonResult)
-}
+	if !checkToken(r) {
+		writeSearchError(w, r, errBadAccessToken)
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+	if err != nil {
+		writeSearchError(w, r, &searchError{http.StatusBadRequest, schema.CodeBadId, "id must be integer", "id", nil})
+		return
+	}
+
+	for _, row := range rows {
+		if row.Id == id {
+			jsonResult, _ := json.Marshal(rowToUser(row))
+			w.Header().Set("Content-Type", schema.MediaTypeJSON)
+			w.WriteHeader(http.StatusOK)
+			w.Write(jsonResult)
+			return
+		}
+	}
 
-type Root struct {
-	XMLName xml.Name `xml:"root"`
-	Rows    []Row    `xml:"row"`
+	writeSearchError(w, r, &searchError{http.StatusNotFound, schema.CodeUserNotFound, "user not found", "id", nil})
 }
 
-type Row struct {
-	Id            int    `xml:"id"`
-	Guid          string `xml:"guid"`
-	IsActive      string `xml:"isActive"`
-	Balance       string `xml:"balance"`
-	Picture       string `xml:"picture"`
-	Age           int    `xml:"age"`
-	EyeColor      string `xml:"eyeColor"`
-	FirstName     string `xml:"first_name"`
-	LastName      string `xml:"last_name"`
-	Gender        string `xml:"gender"`
-	Company       string `xml:"company"`
-	Email         string `xml:"email"`
-	Phone         string `xml:"phone"`
-	Address       string `xml:"address"`
-	About         string `xml:"about"`
-	Registered    string `xml:"registered"`
-	FavoriteFruit string `xml:"favoriteFruit"`
+// UsersServer отдаёт пользователей по списку /users?id=1&id=2 в порядке запроса.
+// Неизвестные id просто пропускаются, их отсутствие замечает клиент
+func UsersServer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeSearchError(w, r, errMethodNotAllowed)
+		return
+	}
+
+	rows, err := loadRows()
+	if err != nil {
+		writeSearchError(w, r, errUnavailable)
+		return
+	}
+
+	if !checkToken(r) {
+		writeSearchError(w, r, errBadAccessToken)
+		return
+	}
+
+	byId := make(map[int]Row, len(rows))
+	for _, row := range rows {
+		byId[row.Id] = row
+	}
+
+	users := make([]User, 0)
+	for _, idStr := range r.URL.Query()["id"] {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			writeSearchError(w, r, &searchError{http.StatusBadRequest, schema.CodeBadId, "id must be integer", "id", nil})
+			return
+		}
+		if row, ok := byId[id]; ok {
+			users = append(users, rowToUser(row))
+		}
+	}
+
+	jsonResult, _ := json.Marshal(users)
+	w.Header().Set("Content-Type", schema.MediaTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResult)
 }
 
+// Root и Row - формат dataset.xml, описан в пакете schema
+type (
+	Root = schema.Root
+	Row  = schema.Row
+)
+
+// сортировки - полные порядки: при равных значениях поля первым идёт меньший Id.
+// Иначе порядок одинаковых записей зависит от алгоритма сортировки, и страницы,
+// запрошенные по отдельности, могут терять и повторять записи на стыке
 type ByAge []Row
 
-func (a ByAge) Len() int           { return len(a) }
-func (a ByAge) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a ByAge) Less(i, j int) bool { return a[i].Age < a[j].Age }
+func (a ByAge) Len() int      { return len(a) }
+func (a ByAge) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByAge) Less(i, j int) bool {
+	if a[i].Age != a[j].Age {
+		return a[i].Age < a[j].Age
+	}
+	return a[i].Id < a[j].Id
+}
 
 type ById []Row
 
@@ -218,18 +642,10 @@ type ByName []Row
 func (a ByName) Len() int      { return len(a) }
 func (a ByName) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 func (a ByName) Less(i, j int) bool {
-	return strings.Compare(a[i].LastName+" "+a[i].FirstName, a[j].LastName+" "+a[j].FirstName) < 0
-}
-
-func (cur *User) Equals(compareTo *User) bool {
-	if cur == compareTo {
-		return true
-	}
-
-	if cur.Id != compareTo.Id || cur.Age != compareTo.Age || cur.About != compareTo.About || cur.Name != compareTo.Name || cur.Gender != compareTo.Gender {
-		return false
+	if c := strings.Compare(a[i].LastName+" "+a[i].FirstName, a[j].LastName+" "+a[j].FirstName); c != 0 {
+		return c < 0
 	}
-	return true
+	return a[i].Id < a[j].Id
 }
 
 func TestClientAllOkWithOffset(t *testing.T) {
@@ -474,10 +890,8 @@ func TestClientWrongOffset(t *testing.T) {
 }
 
 func TestClientTimeOut(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		time.Sleep(2 * time.Second)
-	}))
-	defer ts.Close()
+	ts := fakesearch.New(t)
+	ts.Next().Delay(2 * time.Second)
 	client := SearchClient{
 		AccessToken: "TestToken",
 		URL:         ts.URL,
@@ -491,9 +905,11 @@ func TestClientTimeOut(t *testing.T) {
 	}
 	response, err := client.FindUsers(request)
 
-	if response != nil || err.Error() != "timeout for limit=2&offset=0&order_by=0&order_field=&query=" {
+	if response != nil || err.Error() != "timeout for limit=2&offset=0&order_by=0&order_field=" {
 		t.Error("test failed - must be timeout error")
 	}
+	ts.AssertSearchRequest(SearchRequest{Limit: 2})
+	ts.AssertHeader("AccessToken", "TestToken")
 }
 
 func TestClientNotAuthorized(t *testing.T) {
@@ -536,6 +952,20 @@ func TestClientBadUrl(t *testing.T) {
 	}
 }
 
+func TestClientUnparsableUrl(t *testing.T) {
+	client := SearchClient{
+		AccessToken: "TestToken",
+		URL:         "http://bad host:%zz",
+	}
+	for _, useJSONBody := range []bool{false, true} {
+		client.UseJSONBody = useJSONBody
+		response, err := client.FindUsers(SearchRequest{Limit: 1})
+		if response != nil || err == nil || !strings.HasPrefix(err.Error(), "unknown error parse ") {
+			t.Errorf("json body %v: test failed - must be parse error, got %v", useJSONBody, err)
+		}
+	}
+}
+
 func TestClientBadRequest(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
 	defer ts.Close()
@@ -558,11 +988,8 @@ func TestClientBadRequest(t *testing.T) {
 }
 
 func TestFindUsersStatusInternalServerError(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(""))
-	}))
-	defer ts.Close()
+	ts := fakesearch.New(t)
+	ts.Next().Status(http.StatusInternalServerError)
 
 	client := SearchClient{
 		AccessToken: "TestToken",
@@ -582,8 +1009,8 @@ func TestFindUsersStatusInternalServerError(t *testing.T) {
 }
 
 func TestFindUsersUnknownError(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
-	defer ts.Close()
+	ts := fakesearch.New(t)
+	ts.Next().Error(http.StatusBadRequest, "ErrorFromTheFuture", "")
 
 	client := SearchClient{
 		AccessToken: "TestToken",
@@ -604,11 +1031,8 @@ func TestFindUsersUnknownError(t *testing.T) {
 }
 
 func TestServerWrongServer(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("{bad Json}"))
-	}))
-	defer ts.Close()
+	ts := fakesearch.New(t)
+	ts.Next().Status(http.StatusBadRequest).Body("{bad Json}")
 
 	client := SearchClient{
 		AccessToken: "TestToken",
@@ -628,12 +1052,35 @@ func TestServerWrongServer(t *testing.T) {
 	}
 }
 
+// TestServerHTMLErrorBody - html вместо json в ответе не 400 отдаёт прокси, ошибка определяется по статусу
+func TestServerHTMLErrorBody(t *testing.T) {
+	ts := fakesearch.New(t)
+	ts.Next().Status(http.StatusUnauthorized).Header("Content-Type", "text/html").Body("<html><body>401 Authorization Required</body></html>")
+	ts.Next().Status(http.StatusNotFound).Header("Content-Type", "text/html").Body("<html><body>404 Not Found</body></html>")
+
+	client := SearchClient{
+		AccessToken: "TestToken",
+		URL:         ts.URL,
+	}
+	_, err := client.FindUsers(SearchRequest{Limit: 1})
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("test failed - html 401 must be ErrUnauthorized, got %v", err)
+	}
+
+	_, err = client.FindUsers(SearchRequest{Limit: 1})
+	sErr := &SearchError{}
+	if !errors.As(err, &sErr) || sErr.StatusCode != http.StatusNotFound {
+		t.Errorf("test failed - html 404 must be SearchError with status, got %v", err)
+	}
+	dErr := &DecodeError{}
+	if errors.As(err, &dErr) {
+		t.Errorf("test failed - html 404 must not be DecodeError")
+	}
+}
+
 func TestServerWrongData(t *testing.T) {
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("{bad Json}"))
-	}))
-	defer ts.Close()
+	ts := fakesearch.New(t)
+	ts.Next().Body("{bad Json}")
 
 	client := SearchClient{
 		AccessToken: "TestToken",
@@ -652,3 +1099,647 @@ func TestServerWrongData(t *testing.T) {
 		t.Error("test failed - must be cant unpack result json error")
 	}
 }
+
+func TestFindUsersBatch(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	client := SearchClient{
+		AccessToken: "TestToken",
+		URL:         ts.URL,
+	}
+	requests := []SearchRequest{
+		{Limit: 1, Offset: 1, OrderField: "Id", OrderBy: 1},
+		{Limit: 1, OrderField: "badfield"},
+		{Limit: -1},
+		{Limit: 10, Offset: 33},
+	}
+
+	results, err := client.FindUsersBatch(requests)
+	if err != nil {
+		t.Errorf("error happened: %v", err)
+		return
+	}
+	if len(results) != len(requests) {
+		t.Errorf("test failed - wrong results count %d", len(results))
+		return
+	}
+
+	if results[0].Err != nil || len(results[0].Response.Users) != 1 || results[0].Response.Users[0].Id != 1 || !results[0].Response.NextPage {
+		t.Errorf("test failed - wrong first result %+v", results[0])
+	}
+	if results[1].Response != nil || results[1].Err.Error() != "OrderFeld badfield invalid" {
+		t.Errorf("test failed - second result must be bad order field error, got %+v", results[1])
+	}
+	if results[2].Response != nil || results[2].Err.Error() != "limit must be > 0" {
+		t.Errorf("test failed - third result must be limit error, got %+v", results[2])
+	}
+	if results[3].Err != nil || len(results[3].Response.Users) != 2 || results[3].Response.NextPage {
+		t.Errorf("test failed - wrong last result %+v", results[3])
+	}
+}
+
+func TestFindUsersBatchNotAuthorized(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	client := SearchClient{
+		AccessToken: "WrongToken",
+		URL:         ts.URL,
+	}
+	results, err := client.FindUsersBatch([]SearchRequest{{Limit: 1}})
+	if results != nil || err == nil || err.Error() != "Bad AccessToken" {
+		t.Error("test failed - must be auth error")
+	}
+}
+
+func TestFindUsersBatchAllInvalid(t *testing.T) {
+	client := SearchClient{
+		AccessToken: "TestToken",
+		URL:         "BadUrl",
+	}
+	results, err := client.FindUsersBatch([]SearchRequest{{Offset: -1}})
+	if err != nil || len(results) != 1 || results[0].Err.Error() != "offset must be > 0" {
+		t.Error("test failed - invalid requests must not be sent")
+	}
+}
+
+func TestFindUsersBatchWrongData(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`[]`))
+	}))
+	defer ts.Close()
+
+	client := SearchClient{
+		AccessToken: "TestToken",
+		URL:         ts.URL,
+	}
+	results, err := client.FindUsersBatch([]SearchRequest{{Limit: 1}})
+	if results != nil || err == nil || !strings.Contains(err.Error(), "batch size mismatch") {
+		t.Error("test failed - must be batch size mismatch error")
+	}
+}
+
+func TestSearchBatchServerWrongMethod(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/users/batch")
+	if err != nil {
+		t.Errorf("error happened: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("test failed - wrong status %d", resp.StatusCode)
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	client := SearchClient{
+		AccessToken: "TestToken",
+		URL:         ts.URL,
+	}
+	user, err := client.GetUser(34)
+	if err != nil {
+		t.Errorf("error happened: %v", err)
+		return
+	}
+	if user.Id != 34 || user.Name != "Sharp Kane" || user.Age != 34 {
+		t.Errorf("test failed - wrong user %+v", user)
+	}
+}
+
+func TestGetUserNotFound(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	client := SearchClient{
+		AccessToken: "TestToken",
+		URL:         ts.URL,
+	}
+	user, err := client.GetUser(1000)
+
+	notFound, ok := err.(*UserNotFoundError)
+	if user != nil || !ok || !reflect.DeepEqual(notFound.Ids, []int{1000}) {
+		t.Errorf("test failed - must be not found error, got %v", err)
+	}
+}
+
+func TestGetUserNotFoundOtherCode(t *testing.T) {
+	ts := fakesearch.New(t)
+	ts.Next().Error(http.StatusNotFound, "ErrorNoSuchRoute", "no such route")
+	ts.Next().Status(http.StatusNotFound)
+
+	client := SearchClient{
+		AccessToken: "TestToken",
+		URL:         ts.URL,
+	}
+	for i := 0; i < 2; i++ {
+		user, err := client.GetUser(1)
+		sErr, ok := err.(*SearchError)
+		if user != nil || !ok || sErr.StatusCode != http.StatusNotFound {
+			t.Errorf("test failed - 404 without user code must stay SearchError, got %v", err)
+		}
+	}
+}
+
+func TestGetUserNotAuthorized(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	client := SearchClient{
+		AccessToken: "WrongToken",
+		URL:         ts.URL,
+	}
+	user, err := client.GetUser(1)
+	if user != nil || err == nil || err.Error() != "Bad AccessToken" {
+		t.Error("test failed - must be auth error")
+	}
+}
+
+func TestGetUsers(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	client := SearchClient{
+		AccessToken: "TestToken",
+		URL:         ts.URL,
+	}
+	users, err := client.GetUsers(34, 1000, 1, 2000)
+
+	notFound, ok := err.(*UserNotFoundError)
+	if !ok || !reflect.DeepEqual(notFound.Ids, []int{1000, 2000}) {
+		t.Errorf("test failed - must be not found error, got %v", err)
+		return
+	}
+	if len(users) != 2 || users[0].Id != 34 || users[1].Id != 1 {
+		t.Errorf("test failed - wrong users %+v", users)
+	}
+}
+
+func TestGetUsersAllFound(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	client := SearchClient{
+		AccessToken: "TestToken",
+		URL:         ts.URL,
+	}
+	users, err := client.GetUsers(0, 1)
+	if err != nil || len(users) != 2 || users[0].Name != "Wolf Boyd" || users[1].Name != "Mayer Hilda" {
+		t.Errorf("test failed - wrong users %+v, %v", users, err)
+	}
+}
+
+func TestClientJSONBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+
+	client := SearchClient{
+		AccessToken: "TestToken",
+		URL:         ts.URL,
+		UseJSONBody: true,
+	}
+	request := SearchRequest{
+		Limit:      1,
+		Offset:     1,
+		Query:      "",
+		OrderField: "Id",
+		OrderBy:    1,
+	}
+	result, err := client.FindUsers(request)
+	if err != nil {
+		t.Errorf("error happened: %v", err)
+		return
+	}
+	if len(result.Users) != 1 || result.Users[0].Id != 1 || !result.NextPage {
+		t.Errorf("test failed - wrong result %+v", result)
+	}
+
+	request.OrderField = "badfield"
+	result, err = client.FindUsers(request)
+	if result != nil || err == nil || err.Error() != "OrderFeld badfield invalid" {
+		t.Error("test failed - must be Bad Request error")
+	}
+}
+
+func TestServerJSONBodyWrongContentType(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("POST", ts.URL, strings.NewReader(`{"limit":1}`))
+	req.Header.Add("AccessToken", "TestToken")
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("error happened: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("test failed - wrong status %d", resp.StatusCode)
+	}
+}
+
+func TestServerJSONBodyBadJson(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("POST", ts.URL, strings.NewReader(`{bad Json}`))
+	req.Header.Add("AccessToken", "TestToken")
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("error happened: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("test failed - wrong status %d", resp.StatusCode)
+	}
+}
+
+func TestClientAPIVersions(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	request := SearchRequest{
+		Limit:      1,
+		Offset:     1,
+		Query:      "",
+		OrderField: "Id",
+		OrderBy:    1,
+	}
+	for _, version := range []string{"", APIVersionV1, APIVersionV2} {
+		client := SearchClient{
+			AccessToken: "TestToken",
+			URL:         ts.URL,
+			APIVersion:  version,
+		}
+		result, err := client.FindUsers(request)
+		if err != nil {
+			t.Errorf("version %q: error happened: %v", version, err)
+			continue
+		}
+		if len(result.Users) != 1 || result.Users[0].Id != 1 || !result.NextPage {
+			t.Errorf("version %q: test failed - wrong result %+v", version, result)
+		}
+	}
+}
+
+func TestClientAPIV2LastPage(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	client := SearchClient{
+		AccessToken: "TestToken",
+		URL:         ts.URL,
+		APIVersion:  APIVersionV2,
+	}
+	result, err := client.FindUsers(SearchRequest{Limit: 10, Offset: 33})
+	if err != nil {
+		t.Errorf("error happened: %v", err)
+		return
+	}
+	if len(result.Users) != 2 || result.NextPage {
+		t.Errorf("test failed - wrong result %+v", result)
+	}
+
+	user, err := client.GetUser(33)
+	if err != nil || user.Name != "Snow Twila" {
+		t.Errorf("test failed - wrong user %+v, %v", user, err)
+	}
+}
+
+func TestServerNotAcceptable(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	cases := []struct {
+		path   string
+		accept string
+		status int
+	}{
+		{"/v1/users/search", "text/xml", http.StatusNotAcceptable},
+		{"/v1/users/search", schema.MediaTypeV2, http.StatusNotAcceptable},
+		{"/v1/users/search", "text/html, */*;q=0.8", http.StatusOK},
+		{"/v2/users/search", schema.MediaTypeV2, http.StatusOK},
+		{"/v2/users/search", "application/*", http.StatusOK},
+		{"/v2/users/search", "text/plain", http.StatusNotAcceptable},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest("GET", ts.URL+c.path+"?limit=1", nil)
+		req.Header.Add("AccessToken", "TestToken")
+		req.Header.Set("Accept", c.accept)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("error happened: %v", err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("%s with Accept %q: test failed - wrong status %d", c.path, c.accept, resp.StatusCode)
+		}
+	}
+}
+
+func TestClientNotAcceptable(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotAcceptable)
+	}))
+	defer ts.Close()
+
+	client := SearchClient{
+		AccessToken: "TestToken",
+		URL:         ts.URL,
+		APIVersion:  APIVersionV2,
+	}
+	response, err := client.FindUsers(SearchRequest{Limit: 1})
+	if response != nil || err == nil || !strings.Contains(err.Error(), "does not support requested format") {
+		t.Error("test failed - must be not acceptable error")
+	}
+}
+
+func TestFindUsersTypedErrors(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	cases := []struct {
+		name       string
+		client     SearchClient
+		request    SearchRequest
+		statusCode int
+		code       string
+		field      string
+		class      error
+	}{
+		{
+			name:       "bad token",
+			client:     SearchClient{AccessToken: "WrongToken", URL: ts.URL},
+			statusCode: http.StatusUnauthorized,
+			code:       schema.CodeBadAccessToken,
+			field:      "AccessToken",
+			class:      ErrUnauthorized,
+		},
+		{
+			name:       "user not found",
+			client:     SearchClient{AccessToken: "TestToken", URL: ts.URL + "/users/1000"},
+			statusCode: http.StatusNotFound,
+			code:       schema.CodeUserNotFound,
+			field:      "id",
+			class:      ErrBadRequest,
+		},
+	}
+	for _, c := range cases {
+		response, err := c.client.FindUsers(c.request)
+		sErr, ok := err.(*SearchError)
+		if response != nil || !ok {
+			t.Errorf("%s: test failed - must be *SearchError, got %v", c.name, err)
+			continue
+		}
+		if sErr.StatusCode != c.statusCode || sErr.Code != c.code || sErr.Field != c.field || sErr.Message == "" || sErr.RequestID == "" {
+			t.Errorf("%s: test failed - wrong error %+v", c.name, sErr)
+		}
+		if !errors.Is(err, c.class) {
+			t.Errorf("%s: test failed - wrong error class", c.name)
+		}
+	}
+}
+
+func TestServerErrorBodies(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	cases := []struct {
+		method string
+		path   string
+		status int
+		code   string
+	}{
+		{"GET", "/?limit=x", http.StatusBadRequest, schema.CodeBadLimit},
+		{"GET", "/?offset=-1", http.StatusBadRequest, schema.CodeBadOffset},
+		{"GET", "/?order_by=2", http.StatusBadRequest, schema.CodeBadOrderBy},
+		{"GET", "/?order_field=badfield", http.StatusBadRequest, schema.CodeBadOrderField},
+		{"GET", "/?limit=27", http.StatusBadRequest, schema.CodeBadLimit},
+		{"DELETE", "/", http.StatusMethodNotAllowed, schema.CodeMethodNotAllowed},
+		{"GET", "/users/abc", http.StatusBadRequest, schema.CodeBadId},
+		{"GET", "/users/1000", http.StatusNotFound, schema.CodeUserNotFound},
+		{"GET", "/users?id=abc", http.StatusBadRequest, schema.CodeBadId},
+		{"POST", "/users/batch", http.StatusBadRequest, schema.CodeBadBody},
+		{"POST", "/", http.StatusUnsupportedMediaType, schema.CodeUnsupportedMediaType},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader("{bad Json}"))
+		req.Header.Add("AccessToken", "TestToken")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("error happened: %v", err)
+			continue
+		}
+		errResp := SearchErrorResponse{}
+		err = json.NewDecoder(resp.Body).Decode(&errResp)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != c.status || errResp.Error != c.code || errResp.Message == "" || errResp.RequestID == "" {
+			t.Errorf("%s %s: test failed - wrong response %d %+v, %v", c.method, c.path, resp.StatusCode, errResp, err)
+		}
+	}
+}
+
+// Контракт для пустого результата: запрос, под который ничего не подошло, - это успех с пустым списком,
+// а не ошибка. Проверяем и сервер на всех путях, и клиента во всех режимах
+func TestContractEmptyResultServer(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	cases := []struct {
+		method string
+		path   string
+		accept string
+		body   string
+		want   string
+	}{
+		{"GET", "/?limit=2&query=SomeWrongParameter", "", "", `[]`},
+		{"GET", "/v1/users/search?limit=2&query=SomeWrongParameter", schema.MediaTypeJSON, "", `[]`},
+		{"GET", "/v2/users/search?limit=2&query=SomeWrongParameter", schema.MediaTypeV2, "", `{"users":[],"next_page":false}`},
+		{"POST", "/", "", `{"limit":2,"query":"SomeWrongParameter"}`, `[]`},
+		{"POST", "/users/batch", "", `[{"limit":2,"query":"SomeWrongParameter"}]`, `[{"status_code":200,"body":[]}]`},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader(c.body))
+		req.Header.Add("AccessToken", "TestToken")
+		req.Header.Set("Content-Type", schema.MediaTypeJSON)
+		if c.accept != "" {
+			req.Header.Set("Accept", c.accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("error happened: %v", err)
+			continue
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != c.want {
+			t.Errorf("%s %s: test failed - got %d %s, expected 200 %s", c.method, c.path, resp.StatusCode, body, c.want)
+		}
+	}
+}
+
+func TestContractEmptyResultClient(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	expectedResult := &SearchResponse{
+		Users:    []User{},
+		NextPage: false,
+	}
+	request := SearchRequest{
+		Limit: 2,
+		Query: "SomeWrongParameter",
+	}
+	clients := map[string]SearchClient{
+		"legacy":    {AccessToken: "TestToken", URL: ts.URL},
+		"v1":        {AccessToken: "TestToken", URL: ts.URL, APIVersion: APIVersionV1},
+		"v2":        {AccessToken: "TestToken", URL: ts.URL, APIVersion: APIVersionV2},
+		"json body": {AccessToken: "TestToken", URL: ts.URL, UseJSONBody: true},
+	}
+	for name, client := range clients {
+		result, err := client.FindUsers(request)
+		if err != nil {
+			t.Errorf("%s: error happened: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(expectedResult, result) {
+			t.Errorf("%s: test failed - results not match\nGot:\n%v\nExpected:\n%v", name, result, expectedResult)
+		}
+	}
+
+	client := clients["legacy"]
+	results, err := client.FindUsersBatch([]SearchRequest{request})
+	if err != nil || len(results) != 1 || results[0].Err != nil || !reflect.DeepEqual(expectedResult, results[0].Response) {
+		t.Errorf("batch: test failed - wrong result %+v, %v", results, err)
+	}
+}
+
+func TestContractEmptyPageAfterLastElement(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	client := SearchClient{
+		AccessToken: "TestToken",
+		URL:         ts.URL,
+	}
+	result, err := client.FindUsers(SearchRequest{Limit: 5, Offset: 100})
+	if err != nil || result.NextPage || result.Users == nil || len(result.Users) != 0 {
+		t.Errorf("test failed - wrong result %+v, %v", result, err)
+	}
+}
+
+func TestClientValidationError(t *testing.T) {
+	client := SearchClient{
+		AccessToken: "TestToken",
+		URL:         "BadUrl",
+	}
+	response, err := client.FindUsers(SearchRequest{Limit: -1, Offset: -1, OrderField: "badfield", OrderBy: 2})
+
+	vErr, ok := err.(*ValidationError)
+	if response != nil || !ok {
+		t.Errorf("test failed - must be *ValidationError, got %v", err)
+		return
+	}
+	fields := []string{}
+	for _, fieldErr := range vErr.Errors {
+		fields = append(fields, fieldErr.Field)
+	}
+	if !reflect.DeepEqual(fields, []string{"limit", "offset", "order_by", "order_field"}) {
+		t.Errorf("test failed - wrong fields %v", fields)
+	}
+	if !errors.Is(err, ErrBadRequest) {
+		t.Error("test failed - validation error must be bad request")
+	}
+}
+
+func TestClientCustomRules(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+
+	client := SearchClient{
+		AccessToken: "TestToken",
+		URL:         ts.URL,
+		Rules:       &ValidationRules{MaxLimit: 3, MaxOffset: 10},
+	}
+	result, err := client.FindUsers(SearchRequest{Limit: 10})
+	if err != nil || len(result.Users) != 3 || !result.NextPage {
+		t.Errorf("test failed - limit must be capped to 3, got %+v, %v", result, err)
+	}
+
+	result, err = client.FindUsers(SearchRequest{Limit: 1, Offset: 11})
+	if _, ok := err.(*ValidationError); result != nil || !ok || err.Error() != "offset must be <= 10" {
+		t.Errorf("test failed - must be offset validation error, got %v", err)
+	}
+}
+
+// TestClientNoMaxLimit - нулевой MaxLimit, как и нулевой MaxOffset, снимает ограничение, а не урезает Limit до нуля
+func TestClientNoMaxLimit(t *testing.T) {
+	serverRules = ValidationRules{}
+	defer func() { serverRules = DefaultValidationRules }()
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+
+	client := SearchClient{AccessToken: "TestToken", URL: ts.URL, Rules: &ValidationRules{}}
+	result, err := client.FindUsers(SearchRequest{Limit: 30, Offset: 1})
+	if err != nil || len(result.Users) != 30 || !result.NextPage {
+		t.Errorf("test failed - limit must not be capped, got %+v, %v", result, err)
+	}
+}
+
+func TestServerValidationErrorList(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL+"?limit=x&offset=-1&order_field=badfield", nil)
+	req.Header.Add("AccessToken", "TestToken")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Errorf("error happened: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	errResp := SearchErrorResponse{}
+	json.NewDecoder(resp.Body).Decode(&errResp)
+	expectedErrors := []FieldError{
+		{Field: "limit", Code: schema.CodeBadLimit, Message: "Limit must be integer"},
+		{Field: "offset", Code: schema.CodeBadOffset, Message: "offset must be > 0"},
+		{Field: "order_field", Code: schema.CodeBadOrderField, Message: "OrderFeld badfield invalid"},
+	}
+	if resp.StatusCode != http.StatusBadRequest || errResp.Error != schema.CodeBadLimit || !reflect.DeepEqual(errResp.Errors, expectedErrors) {
+		t.Errorf("test failed - wrong response %d %+v", resp.StatusCode, errResp)
+	}
+}
+
+func TestServerGeneratedDataset(t *testing.T) {
+	path := t.TempDir() + "/users.json"
+	if err := datagen.WriteFile(path, datagen.Generate(datagen.Config{Seed: 1, Rows: 300})); err != nil {
+		t.Fatalf("test failed - %s", err)
+	}
+	defer func(old string) { datasetPath = old }(datasetPath)
+	datasetPath = path
+
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+	client := SearchClient{AccessToken: "TestToken", URL: ts.URL, APIVersion: APIVersionV2}
+	result, err := client.FindUsers(SearchRequest{Limit: 5, Offset: 295, OrderField: "Id", OrderBy: 1})
+	if err != nil || len(result.Users) != 5 || result.Users[4].Id != 299 || result.NextPage {
+		t.Errorf("test failed - %+v, %v", result, err)
+	}
+
+	datasetPath = t.TempDir() + "/missing.xml"
+	if _, err = client.FindUsers(SearchRequest{Limit: 5}); !errors.Is(err, ErrFatal) {
+		t.Errorf("test failed - missing dataset must be fatal, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"lesson4/datagen"
+)
+
+// datagen пишет синтетический датасет для внешней системы поиска:
+//
+//	datagen -rows 100000 -seed 7 -age-dist normal -dup-names 0.2 -o users-100k.xml
+//
+// Формат берётся из расширения -o или из -format, без -o датасет пишется в stdout
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	def := datagen.DefaultConfig
+	flags := flag.NewFlagSet("datagen", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var (
+		rows        = flags.Int("rows", def.Rows, "сколько записей")
+		seed        = flags.Int64("seed", 1, "seed генератора, одинаковый seed - одинаковый датасет")
+		output      = flags.String("o", "", "файл датасета, по умолчанию stdout")
+		format      = flags.String("format", "", "формат: xml или json, по умолчанию по расширению -o, иначе xml")
+		ageMin      = flags.Float64("age-min", def.Age.Min, "минимальный возраст")
+		ageMax      = flags.Float64("age-max", def.Age.Max, "максимальный возраст")
+		ageDist     = flags.String("age-dist", string(def.Age.Distribution), "распределение возраста: uniform, normal или exponential")
+		balanceMin  = flags.Float64("balance-min", def.Balance.Min, "минимальный баланс")
+		balanceMax  = flags.Float64("balance-max", def.Balance.Max, "максимальный баланс")
+		balanceDist = flags.String("balance-dist", string(def.Balance.Distribution), "распределение баланса")
+		regFrom     = flags.String("registered-from", def.RegisteredFrom.Format("2006-01-02"), "самая ранняя дата регистрации")
+		regTo       = flags.String("registered-to", def.RegisteredTo.Format("2006-01-02"), "самая поздняя дата регистрации")
+		regDist     = flags.String("registered-dist", string(def.Registered), "распределение дат регистрации")
+		aboutMin    = flags.Float64("about-min", def.AboutWords.Min, "минимум слов в About")
+		aboutMax    = flags.Float64("about-max", def.AboutWords.Max, "максимум слов в About")
+		aboutDist   = flags.String("about-dist", string(def.AboutWords.Distribution), "распределение числа слов в About")
+		dupNames    = flags.Float64("dup-names", 0, "доля записей с именем из предыдущих записей")
+		dupAbout    = flags.Float64("dup-about", 0, "доля записей с About из предыдущих записей")
+		active      = flags.Float64("active", def.ActiveRate, "доля активных пользователей")
+	)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	config := datagen.Config{
+		Seed:               *seed,
+		Rows:               *rows,
+		Age:                datagen.Range{Min: *ageMin, Max: *ageMax, Distribution: datagen.Distribution(*ageDist)},
+		Balance:            datagen.Range{Min: *balanceMin, Max: *balanceMax, Distribution: datagen.Distribution(*balanceDist)},
+		Registered:         datagen.Distribution(*regDist),
+		AboutWords:         datagen.Range{Min: *aboutMin, Max: *aboutMax, Distribution: datagen.Distribution(*aboutDist)},
+		NameDuplicateRate:  *dupNames,
+		AboutDuplicateRate: *dupAbout,
+		ActiveRate:         *active,
+	}
+	var err error
+	if config.RegisteredFrom, err = time.Parse("2006-01-02", *regFrom); err != nil {
+		fmt.Fprintf(stderr, "datagen: -registered-from: %s\n", err)
+		return 2
+	}
+	if config.RegisteredTo, err = time.Parse("2006-01-02", *regTo); err != nil {
+		fmt.Fprintf(stderr, "datagen: -registered-to: %s\n", err)
+		return 2
+	}
+	if err = config.Validate(); err != nil {
+		fmt.Fprintf(stderr, "datagen: %s\n", err)
+		return 2
+	}
+
+	dataFormat := datagen.FormatXML
+	switch {
+	case *format != "":
+		dataFormat, err = datagen.ParseFormat(*format)
+	case *output != "":
+		dataFormat, err = datagen.FormatFromPath(*output)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "datagen: %s\n", err)
+		return 2
+	}
+
+	if *output == "" {
+		err = write(stdout, dataFormat, config)
+	} else {
+		err = writeFile(*output, dataFormat, config)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "datagen: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func writeFile(path string, format datagen.Format, config datagen.Config) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = write(file, format, config)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// write пишет записи по мере генерации, так что миллион записей не лежит в памяти целиком
+func write(w io.Writer, format datagen.Format, config datagen.Config) error {
+	writer, err := datagen.NewWriter(w, format)
+	if err != nil {
+		return err
+	}
+	gen := datagen.New(config)
+	for {
+		row, ok := gen.Next()
+		if !ok {
+			return writer.Close()
+		}
+		if err = writer.Write(row); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"lesson4/datagen"
+)
+
+func TestRunStdout(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run([]string{"-rows", "3", "-seed", "2", "-format", "json"}, stdout, stderr)
+	if code != 0 {
+		t.Fatalf("test failed - exit code %d, stderr %s", code, stderr)
+	}
+	rows, err := datagen.Read(stdout, datagen.FormatJSON)
+	if err != nil || len(rows) != 3 {
+		t.Errorf("test failed - %d rows, %v", len(rows), err)
+	}
+}
+
+func TestRunFile(t *testing.T) {
+	path := t.TempDir() + "/users.xml"
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run([]string{"-rows", "20", "-o", path, "-age-min", "30", "-age-max", "31", "-dup-about", "0.5"}, stdout, stderr)
+	if code != 0 || stdout.Len() != 0 {
+		t.Fatalf("test failed - exit code %d, stderr %s", code, stderr)
+	}
+	rows, err := datagen.ReadFile(path)
+	if err != nil || len(rows) != 20 {
+		t.Fatalf("test failed - %d rows, %v", len(rows), err)
+	}
+	for _, row := range rows {
+		if row.Age != 30 && row.Age != 31 {
+			t.Errorf("test failed - age %d out of range", row.Age)
+		}
+	}
+}
+
+func TestRunBadFlags(t *testing.T) {
+	cases := [][]string{
+		{"-rows", "-1"},
+		{"-age-dist", "zipf"},
+		{"-format", "csv"},
+		{"-o", "users.txt"},
+		{"-registered-from", "yesterday"},
+	}
+	for _, args := range cases {
+		stderr := &bytes.Buffer{}
+		if code := run(args, &bytes.Buffer{}, stderr); code != 2 || !strings.HasPrefix(stderr.String(), "datagen: ") {
+			t.Errorf("%v: test failed - exit code %d, stderr %s", args, code, stderr)
+		}
+	}
+}
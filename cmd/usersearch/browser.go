@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"lesson4"
+)
+
+// очистка экрана и перевод курсора в начало
+const clearScreen = "\033[H\033[2J"
+
+// порядок, в котором переключаются колонки и направления сортировки
+var (
+	browserOrderFields = []string{"Name", "Id", "Age"}
+	browserOrderBys    = []int{lesson4.OrderByAsIs, 1, -1}
+)
+
+const browserHelp = `n - следующая страница, p - предыдущая
+/текст - искать текст, / - сбросить поиск
+s - сменить колонку сортировки, d - сменить направление
+номер строки - показать About, q - выход`
+
+// browser - интерактивный просмотр результатов поиска в терминале.
+// Команды читаются построчно из in, каждая отрисовывает экран заново
+type browser struct {
+	srv  *lesson4.SearchClient
+	req  lesson4.SearchRequest
+	page *lesson4.SearchResponse
+	// запрос, которым получена page. Если новый запрос не удался, req откатывается к нему,
+	// чтобы заголовок и следующая страница считались от того, что на экране
+	pageReq lesson4.SearchRequest
+	// сообщение под таблицей: ошибка или подсказка
+	status string
+	out    io.Writer
+}
+
+// runBrowser показывает первую страницу по req и дальше выполняет команды из in до q или конца ввода
+func runBrowser(srv *lesson4.SearchClient, req lesson4.SearchRequest, in io.Reader, out io.Writer) error {
+	if req.OrderField == "" {
+		req.OrderField = browserOrderFields[0]
+	}
+	b := &browser{srv: srv, req: req, out: out}
+	b.load()
+	b.render()
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if !b.command(strings.TrimSpace(scanner.Text())) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// command выполняет одну команду, false - пора выходить
+func (b *browser) command(cmd string) bool {
+	b.status = ""
+	switch {
+	case cmd == "q":
+		return false
+	case cmd == "n":
+		if b.page == nil || !b.page.NextPage {
+			b.status = "это последняя страница"
+			break
+		}
+		b.req.Offset += len(b.page.Users)
+		b.load()
+	case cmd == "p":
+		if b.req.Offset == 0 {
+			b.status = "это первая страница"
+			break
+		}
+		b.req.Offset -= b.pageSize()
+		if b.req.Offset < 0 {
+			b.req.Offset = 0
+		}
+		b.load()
+	case cmd == "s":
+		b.req.OrderField = nextString(browserOrderFields, b.req.OrderField)
+		b.req.Offset = 0
+		b.load()
+	case cmd == "d":
+		b.req.OrderBy = nextInt(browserOrderBys, b.req.OrderBy)
+		b.req.Offset = 0
+		b.load()
+	case strings.HasPrefix(cmd, "/"):
+		b.req.Query = strings.TrimPrefix(cmd, "/")
+		b.req.Offset = 0
+		b.load()
+	case cmd == "?":
+		b.status = browserHelp
+	case cmd == "":
+	default:
+		row, err := strconv.Atoi(cmd)
+		if err != nil || b.page == nil || row < 1 || row > len(b.page.Users) {
+			b.status = fmt.Sprintf("неизвестная команда %q, ? - помощь", cmd)
+			break
+		}
+		b.showAbout(b.page.Users[row-1])
+		return true
+	}
+	b.render()
+	return true
+}
+
+func (b *browser) load() {
+	page, err := b.srv.FindUsers(b.req)
+	if err != nil {
+		b.status = "ошибка: " + err.Error()
+		if b.page != nil {
+			b.req = b.pageReq
+		}
+		return
+	}
+	b.page = page
+	b.pageReq = b.req
+}
+
+// pageSize - сколько записей на странице с учётом того, что клиент урезает Limit
+func (b *browser) pageSize() int {
+	rules := lesson4.DefaultValidationRules
+	if b.srv.Rules != nil {
+		rules = *b.srv.Rules
+	}
+	if b.req.Limit <= 0 && rules.MaxLimit > 0 {
+		return rules.MaxLimit
+	}
+	return rules.ClampLimit(b.req.Limit)
+}
+
+func (b *browser) render() {
+	fmt.Fprint(b.out, clearScreen)
+	direction := map[int]string{lesson4.OrderByAsIs: "как есть", 1: "по возрастанию", -1: "по убыванию"}[b.req.OrderBy]
+	fmt.Fprintf(b.out, "поиск: %q  сортировка: %s, %s  с записи %d\n\n", b.req.Query, b.req.OrderField, direction, b.req.Offset)
+
+	if b.page != nil {
+		fmt.Fprintln(b.out, "  #  ID  NAME")
+		for i, user := range b.page.Users {
+			fmt.Fprintf(b.out, "%3d  %2d  %s (%d, %s)\n", i+1, user.Id, user.Name, user.Age, user.Gender)
+		}
+		if len(b.page.Users) == 0 {
+			fmt.Fprintln(b.out, "  ничего не найдено")
+		}
+		if b.page.NextPage {
+			fmt.Fprintln(b.out, "  ... n - следующая страница")
+		}
+	}
+
+	fmt.Fprintln(b.out)
+	if b.status != "" {
+		fmt.Fprintln(b.out, b.status)
+	}
+	fmt.Fprint(b.out, "> ")
+}
+
+func (b *browser) showAbout(user lesson4.User) {
+	fmt.Fprint(b.out, clearScreen)
+	fmt.Fprintf(b.out, "%d %s, %d, %s\n\n%s\n", user.Id, user.Name, user.Age, user.Gender, strings.TrimSpace(user.About))
+	fmt.Fprint(b.out, "\nEnter - назад к списку\n> ")
+}
+
+// nextString - значение после cur по кругу
+func nextString(values []string, cur string) string {
+	for i, value := range values {
+		if strings.EqualFold(value, cur) {
+			return values[(i+1)%len(values)]
+		}
+	}
+	return values[0]
+}
+
+func nextInt(values []int, cur int) int {
+	for i, value := range values {
+		if value == cur {
+			return values[(i+1)%len(values)]
+		}
+	}
+	return values[0]
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"lesson4"
+	"lesson4/fakesearch"
+	"lesson4/schema"
+)
+
+// screens делит вывод браузера на экраны по очистке терминала
+func screens(out string) []string {
+	return strings.Split(out, clearScreen)[1:]
+}
+
+func TestBrowserPaging(t *testing.T) {
+	ts := fakesearch.New(t)
+	ts.Next().Users(testUsers...)
+	ts.Next().Users(testUsers[2:]...)
+	ts.Next().Users(testUsers...)
+
+	srv := &lesson4.SearchClient{AccessToken: "TestToken", URL: ts.URL}
+	out := &bytes.Buffer{}
+	err := runBrowser(srv, lesson4.SearchRequest{Limit: 2}, strings.NewReader("n\np\np\nq\nn\n"), out)
+	if err != nil {
+		t.Fatalf("error happened: %v", err)
+	}
+
+	got := screens(out.String())
+	if len(got) != 4 {
+		t.Fatalf("test failed - expected 4 screens before q, got %d", len(got))
+	}
+	if !strings.Contains(got[0], "Wolf Boyd") || !strings.Contains(got[0], "n - следующая страница") {
+		t.Errorf("test failed - wrong first page\n%s", got[0])
+	}
+	if !strings.Contains(got[1], "с записи 2") || strings.Contains(got[1], "Wolf Boyd") || strings.Contains(got[1], "n - следующая страница") {
+		t.Errorf("test failed - wrong second page\n%s", got[1])
+	}
+	if !strings.Contains(got[2], "Wolf Boyd") || !strings.Contains(got[3], "это первая страница") {
+		t.Errorf("test failed - p must return to the first page and stop there\n%s", got[3])
+	}
+	requests := ts.Requests()
+	if len(requests) != 3 || requests[1].Query.Get("offset") != "2" || requests[2].Query.Get("offset") != "0" {
+		t.Errorf("test failed - wrong offsets requested: %+v", requests)
+	}
+}
+
+func TestBrowserQueryAndSort(t *testing.T) {
+	ts := fakesearch.New(t)
+	for i := 0; i < 4; i++ {
+		ts.Next().Users(testUsers...)
+	}
+	ts.Next().Users(testUsers[1])
+	ts.Next().Users()
+
+	srv := &lesson4.SearchClient{AccessToken: "TestToken", URL: ts.URL}
+	out := &bytes.Buffer{}
+	runBrowser(srv, lesson4.SearchRequest{Limit: 3}, strings.NewReader("s\nd\nd\n/Hilda\n1\n\n/NoSuchText\n"), out)
+
+	got := screens(out.String())
+	if len(got) != 8 {
+		t.Fatalf("test failed - expected 8 screens, got %d", len(got))
+	}
+	if !strings.Contains(got[1], "сортировка: Id, как есть") || !strings.Contains(got[2], "сортировка: Id, по возрастанию") ||
+		!strings.Contains(got[3], "сортировка: Id, по убыванию") {
+		t.Errorf("test failed - s must switch column, d must switch direction\n%s\n%s\n%s", got[1], got[2], got[3])
+	}
+	requests := ts.Requests()
+	if len(requests) != 6 {
+		t.Fatalf("test failed - expected 6 searches, got %d", len(requests))
+	}
+	for i, expected := range []struct{ orderField, orderBy, query string }{
+		{"Name", "0", ""},
+		{"Id", "0", ""},
+		{"Id", "1", ""},
+		{"Id", "-1", ""},
+		{"Id", "-1", "Hilda"},
+		{"Id", "-1", "NoSuchText"},
+	} {
+		query := requests[i].Query
+		if query.Get("order_field") != expected.orderField || query.Get("order_by") != expected.orderBy || query.Get("query") != expected.query {
+			t.Errorf("test failed - search %d sent %v, expected %+v", i, query, expected)
+		}
+	}
+	if !strings.Contains(got[4], `поиск: "Hilda"`) || !strings.Contains(got[4], "Mayer Hilda") || strings.Contains(got[4], "следующая страница") {
+		t.Errorf("test failed - wrong query page\n%s", got[4])
+	}
+	if !strings.HasPrefix(got[5], "1 Mayer Hilda, 21, female\n\nSit commodo consectetur") {
+		t.Errorf("test failed - row number must open About\n%s", got[5])
+	}
+	if !strings.Contains(got[7], "ничего не найдено") {
+		t.Errorf("test failed - wrong empty page\n%s", got[7])
+	}
+}
+
+func TestBrowserErrors(t *testing.T) {
+	ts := fakesearch.New(t)
+	ts.Default().Error(http.StatusUnauthorized, schema.CodeBadAccessToken, "Bad AccessToken")
+
+	srv := &lesson4.SearchClient{AccessToken: "WrongToken", URL: ts.URL}
+	out := &bytes.Buffer{}
+	runBrowser(srv, lesson4.SearchRequest{Limit: 3}, strings.NewReader("n\nx\n?\n"), out)
+
+	got := screens(out.String())
+	if len(got) != 4 || !strings.Contains(got[0], "ошибка: Bad AccessToken") || !strings.Contains(got[1], "последняя страница") ||
+		!strings.Contains(got[2], `неизвестная команда "x"`) || !strings.Contains(got[3], browserHelp) {
+		t.Errorf("test failed - wrong screens\n%s", out)
+	}
+}
+
+// TestBrowserFailedLoad - после неудачного запроса на экране прежняя страница под прежним заголовком,
+// и следующая страница считается от неё
+func TestBrowserFailedLoad(t *testing.T) {
+	ts := fakesearch.New(t)
+	ts.Next().Users(testUsers...)
+	ts.Next().Error(http.StatusInternalServerError, schema.CodeUnavailable, "try later")
+	ts.Next().Error(http.StatusInternalServerError, schema.CodeUnavailable, "try later")
+	ts.Next().Users(testUsers[2:]...)
+
+	srv := &lesson4.SearchClient{AccessToken: "TestToken", URL: ts.URL}
+	out := &bytes.Buffer{}
+	runBrowser(srv, lesson4.SearchRequest{Limit: 2}, strings.NewReader("n\n/Hilda\nn\n"), out)
+
+	got := screens(out.String())
+	if len(got) != 4 {
+		t.Fatalf("test failed - expected 4 screens, got %d\n%s", len(got), out)
+	}
+	for _, screen := range got[1:3] {
+		if !strings.Contains(screen, `поиск: ""`) || !strings.Contains(screen, "с записи 0") ||
+			!strings.Contains(screen, "Wolf Boyd") || !strings.Contains(screen, "ошибка: ") {
+			t.Errorf("test failed - failed load must keep shown page and its header\n%s", screen)
+		}
+	}
+	ts.AssertQuery("offset", "2")
+	ts.AssertQuery("query", "")
+	if !strings.Contains(got[3], "с записи 2") || !strings.Contains(got[3], "Brooks Aguilar") {
+		t.Errorf("test failed - wrong page after failed load\n%s", got[3])
+	}
+}
+
+func TestRunInteractive(t *testing.T) {
+	ts := fakesearch.New(t)
+	ts.Next().Users(testUsers...)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run([]string{"-url", ts.URL, "-token", "TestToken", "-i", "-limit", "1"}, strings.NewReader("q\n"), stdout, stderr)
+	if code != exitOK || !strings.Contains(stdout.String(), "Wolf Boyd") {
+		t.Errorf("test failed - exit code %d, output %s", code, stdout)
+	}
+}
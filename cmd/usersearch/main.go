@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"lesson4"
+)
+
+// коды выхода usersearch, по одному на класс ошибки
+const (
+	exitOK           = 0
+	exitError        = 1
+	exitBadRequest   = 2
+	exitUnauthorized = 3
+	exitTimeout      = 4
+	exitFatal        = 5
+)
+
+// usersearch - консольный клиент поиска поверх lesson4.SearchClient:
+//
+//	usersearch -url http://localhost:8080 -token TestToken -query Hilda -order-field Age -order-by desc -format csv
+//
+// С -all проходит по всем страницам, пока внешняя система говорит, что есть следующая,
+// с -i открывает интерактивный просмотр, где запрос можно менять на ходу
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("usersearch", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var (
+		searchURL  = flags.String("url", "", "адрес внешней системы поиска")
+		token      = flags.String("token", os.Getenv("USERSEARCH_TOKEN"), "AccessToken, по умолчанию из USERSEARCH_TOKEN")
+		apiVersion = flags.String("api-version", "", "версия API: v1, v2 или пусто для старого пути")
+		query      = flags.String("query", "", "подстрока в Name или About")
+		orderField = flags.String("order-field", "", "поле сортировки: Id, Age или Name")
+		orderBy    = flags.String("order-by", "asis", "направление сортировки: asc, desc или asis")
+		limit      = flags.Int("limit", 25, "размер страницы")
+		offset     = flags.Int("offset", 0, "сколько записей пропустить")
+		all        = flags.Bool("all", false, "пройти по всем страницам начиная с offset")
+		format     = flags.String("format", "table", "формат вывода: table, json или csv")
+		browse     = flags.Bool("i", false, "интерактивный просмотр результатов")
+	)
+	if err := flags.Parse(args); err != nil {
+		return exitBadRequest
+	}
+	if *searchURL == "" {
+		fmt.Fprintln(stderr, "usersearch: -url is required")
+		return exitBadRequest
+	}
+	write, ok := writers[*format]
+	if !ok {
+		fmt.Fprintf(stderr, "usersearch: unknown format %q\n", *format)
+		return exitBadRequest
+	}
+	direction, err := parseOrderBy(*orderBy)
+	if err != nil {
+		fmt.Fprintf(stderr, "usersearch: %s\n", err)
+		return exitBadRequest
+	}
+
+	srv := &lesson4.SearchClient{
+		AccessToken: *token,
+		URL:         *searchURL,
+		APIVersion:  *apiVersion,
+	}
+	req := lesson4.SearchRequest{
+		Limit:      *limit,
+		Offset:     *offset,
+		Query:      *query,
+		OrderField: *orderField,
+		OrderBy:    direction,
+	}
+
+	if *browse {
+		if err = runBrowser(srv, req, stdin, stdout); err != nil {
+			fmt.Fprintf(stderr, "usersearch: %s\n", err)
+			return exitError
+		}
+		return exitOK
+	}
+
+	var result *lesson4.SearchResponse
+	if *all {
+		result, err = findAll(srv, req)
+	} else {
+		result, err = srv.FindUsers(req)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "usersearch: %s\n", err)
+		return exitCode(err)
+	}
+
+	if err = write(stdout, result); err != nil {
+		fmt.Fprintf(stderr, "usersearch: %s\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// findAll собирает все страницы, начиная с req.Offset
+func findAll(srv *lesson4.SearchClient, req lesson4.SearchRequest) (*lesson4.SearchResponse, error) {
+	all := &lesson4.SearchResponse{Users: []lesson4.User{}}
+	for {
+		page, err := srv.FindUsers(req)
+		if err != nil {
+			return nil, err
+		}
+		all.Users = append(all.Users, page.Users...)
+		if !page.NextPage || len(page.Users) == 0 {
+			return all, nil
+		}
+		req.Offset += len(page.Users)
+	}
+}
+
+// parseOrderBy переводит направление в lesson4.SearchRequest.OrderBy: 1 по возрастанию, -1 по убыванию.
+// Константы OrderByAsc/OrderByDesc названы наоборот, поэтому здесь числа
+func parseOrderBy(value string) (int, error) {
+	switch value {
+	case "asc":
+		return 1, nil
+	case "desc":
+		return -1, nil
+	case "asis", "":
+		return lesson4.OrderByAsIs, nil
+	}
+	if orderBy, err := strconv.Atoi(value); err == nil {
+		return orderBy, nil
+	}
+	return 0, fmt.Errorf("order-by must be asc, desc or asis, got %q", value)
+}
+
+// exitCode - код выхода по классу ошибки
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, lesson4.ErrBadRequest):
+		return exitBadRequest
+	case errors.Is(err, lesson4.ErrUnauthorized):
+		return exitUnauthorized
+	case errors.Is(err, lesson4.ErrTimeout):
+		return exitTimeout
+	case errors.Is(err, lesson4.ErrFatal):
+		return exitFatal
+	}
+	return exitError
+}
+
+// writers - форматы вывода результата
+var writers = map[string]func(w io.Writer, result *lesson4.SearchResponse) error{
+	"table": writeTable,
+	"json":  writeJSON,
+	"csv":   writeCSV,
+}
+
+func writeTable(w io.Writer, result *lesson4.SearchResponse) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tAGE\tGENDER")
+	for _, user := range result.Users {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%s\n", user.Id, user.Name, user.Age, user.Gender)
+	}
+	if result.NextPage {
+		fmt.Fprintln(tw, "...\t\t\t")
+	}
+	return tw.Flush()
+}
+
+func writeJSON(w io.Writer, result *lesson4.SearchResponse) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+func writeCSV(w io.Writer, result *lesson4.SearchResponse) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "name", "age", "gender", "about"})
+	for _, user := range result.Users {
+		cw.Write([]string{strconv.Itoa(user.Id), user.Name, strconv.Itoa(user.Age), user.Gender, user.About})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"lesson4"
+	"lesson4/fakesearch"
+	"lesson4/schema"
+)
+
+var testUsers = []lesson4.User{
+	{Id: 0, Name: "Wolf Boyd", Age: 22, Gender: "male", About: "Nulla cillum enim voluptate"},
+	{Id: 1, Name: "Mayer Hilda", Age: 21, Gender: "female", About: "Sit commodo consectetur"},
+	{Id: 2, Name: "Brooks Aguilar", Age: 25, Gender: "male", About: "Velit ullamco est aliqua"},
+}
+
+func TestRunTable(t *testing.T) {
+	ts := fakesearch.New(t)
+	ts.Next().Users(testUsers[1:]...)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run([]string{"-url", ts.URL, "-token", "TestToken", "-order-field", "Id", "-order-by", "asc", "-limit", "1", "-offset", "1"}, nil, stdout, stderr)
+	if code != exitOK {
+		t.Fatalf("test failed - exit code %d, stderr %s", code, stderr)
+	}
+	ts.AssertHeader("AccessToken", "TestToken")
+	ts.AssertQuery("order_field", "Id")
+	ts.AssertQuery("order_by", "1")
+	ts.AssertQuery("offset", "1")
+
+	lines := strings.Split(stdout.String(), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "ID ") || !strings.HasPrefix(lines[1], "1 ") || !strings.Contains(lines[1], "Mayer Hilda") ||
+		strings.TrimSpace(lines[2]) != "..." {
+		t.Errorf("test failed - wrong table\n%s", stdout)
+	}
+}
+
+func TestRunAllJSON(t *testing.T) {
+	ts := fakesearch.New(t)
+	ts.Next().Page(true, testUsers[:2]...)
+	ts.Next().Page(false, testUsers[2:]...)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run([]string{"-url", ts.URL, "-token", "TestToken", "-all", "-limit", "2", "-format", "json", "-api-version", "v2"}, nil, stdout, stderr)
+	if code != exitOK {
+		t.Fatalf("test failed - exit code %d, stderr %s", code, stderr)
+	}
+	result := lesson4.SearchResponse{}
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatalf("cant unpack output: %v", err)
+	}
+	if len(result.Users) != 3 || result.NextPage {
+		t.Errorf("test failed - -all must return all pages, got %d users", len(result.Users))
+	}
+	for i, user := range result.Users {
+		if user.Id != i {
+			t.Errorf("test failed - wrong order, user %d at position %d", user.Id, i)
+			break
+		}
+	}
+	requests := ts.Requests()
+	if len(requests) != 2 || requests[0].Query.Get("offset") != "0" || requests[1].Query.Get("offset") != "2" {
+		t.Errorf("test failed - pages must be requested one after another: %+v", requests)
+	}
+}
+
+func TestRunCSV(t *testing.T) {
+	ts := fakesearch.New(t)
+	ts.Next().Users(testUsers[:2]...)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run([]string{"-url", ts.URL, "-token", "TestToken", "-limit", "1", "-format", "csv"}, nil, stdout, stderr)
+	if code != exitOK || stdout.String() != "id,name,age,gender,about\n0,Wolf Boyd,22,male,Nulla cillum enim voluptate\n" {
+		t.Errorf("test failed - exit code %d, output %s", code, stdout)
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	ts := fakesearch.New(t)
+	ts.Default().Users(testUsers...)
+	unauthorized := fakesearch.New(t)
+	unauthorized.Default().Error(http.StatusUnauthorized, schema.CodeBadAccessToken, "Bad AccessToken")
+	slow := fakesearch.New(t)
+	slow.Default().Delay(2 * time.Second)
+	broken := fakesearch.New(t)
+	broken.Default().Status(http.StatusInternalServerError)
+
+	cases := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"no url", []string{}, exitBadRequest},
+		{"bad flag", []string{"-url", ts.URL, "-nope"}, exitBadRequest},
+		{"bad format", []string{"-url", ts.URL, "-format", "xml"}, exitBadRequest},
+		{"bad order by", []string{"-url", ts.URL, "-order-by", "sideways"}, exitBadRequest},
+		{"validation", []string{"-url", ts.URL, "-token", "TestToken", "-order-field", "badfield"}, exitBadRequest},
+		{"unauthorized", []string{"-url", unauthorized.URL, "-token", "WrongToken"}, exitUnauthorized},
+		{"timeout", []string{"-url", slow.URL, "-token", "TestToken"}, exitTimeout},
+		{"fatal", []string{"-url", broken.URL, "-token", "TestToken"}, exitFatal},
+		{"unknown", []string{"-url", "BadUrl", "-token", "TestToken"}, exitError},
+	}
+	for _, c := range cases {
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		if code := run(c.args, nil, stdout, stderr); code != c.code {
+			t.Errorf("%s: test failed - exit code %d, expected %d, stderr %s", c.name, code, c.code, stderr)
+		}
+	}
+}
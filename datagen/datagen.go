@@ -0,0 +1,343 @@
+// Package datagen генерирует синтетические датасеты в формате dataset.xml любого размера.
+// Одинаковый Config с одинаковым Seed всегда даёт одни и те же записи:
+//
+//	rows := datagen.Generate(datagen.Config{Seed: 1, Rows: 100000, NameDuplicateRate: 0.3})
+//	err := datagen.WriteFile("testdata/users-100k.xml", rows)
+//
+// Записи можно не держать в памяти целиком: Generator отдаёт их по одной, а Writer пишет по одной
+package datagen
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"lesson4/schema"
+)
+
+// Distribution - как значение распределено между Min и Max
+type Distribution string
+
+const (
+	// Uniform - равномерно
+	Uniform Distribution = "uniform"
+	// Normal - нормально с центром посередине и тремя сигмами до краёв, хвосты обрезаны по краям
+	Normal Distribution = "normal"
+	// Exponential - чаще у Min, реже к Max, как балансы и стаж в живых данных
+	Exponential Distribution = "exponential"
+)
+
+// Range - диапазон значений и их распределение
+type Range struct {
+	Min, Max     float64
+	Distribution Distribution
+}
+
+// Config - параметры датасета. Нулевые диапазоны, даты и распределения заменяются на значения из DefaultConfig,
+// нулевые Rows и доли так и остаются нулевыми
+type Config struct {
+	Seed int64
+	Rows int
+
+	Age     Range
+	Balance Range
+	// даты регистрации между RegisteredFrom и RegisteredTo, распределение - Registered
+	RegisteredFrom, RegisteredTo time.Time
+	Registered                   Distribution
+	// число слов в About
+	AboutWords Range
+
+	// доля записей, у которых имя, фамилия и пол повторяют одну из предыдущих записей.
+	// Это сверх случайных совпадений: имён в словаре немного, и на больших датасетах они повторяются и так
+	NameDuplicateRate float64
+	// доля записей, у которых About повторяет одну из предыдущих записей
+	AboutDuplicateRate float64
+	// доля активных пользователей
+	ActiveRate float64
+}
+
+// DefaultConfig - распределения, похожие на dataset.xml. Удобно брать за основу и менять нужные поля
+var DefaultConfig = Config{
+	Rows:           1000,
+	Age:            Range{Min: 20, Max: 40, Distribution: Uniform},
+	Balance:        Range{Min: 1000, Max: 4000, Distribution: Uniform},
+	RegisteredFrom: time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+	RegisteredTo:   time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+	Registered:     Uniform,
+	AboutWords:     Range{Min: 20, Max: 80, Distribution: Uniform},
+	ActiveRate:     0.5,
+}
+
+func (config Config) withDefaults() Config {
+	if config.Age == (Range{}) {
+		config.Age = DefaultConfig.Age
+	}
+	if config.Balance == (Range{}) {
+		config.Balance = DefaultConfig.Balance
+	}
+	if config.RegisteredFrom.IsZero() {
+		config.RegisteredFrom = DefaultConfig.RegisteredFrom
+	}
+	if config.RegisteredTo.IsZero() {
+		config.RegisteredTo = DefaultConfig.RegisteredTo
+	}
+	if config.Registered == "" {
+		config.Registered = DefaultConfig.Registered
+	}
+	if config.AboutWords == (Range{}) {
+		config.AboutWords = DefaultConfig.AboutWords
+	}
+	return config
+}
+
+// Validate проверяет, что диапазоны и доли имеют смысл
+func (config Config) Validate() error {
+	config = config.withDefaults()
+	if config.Rows < 0 {
+		return fmt.Errorf("rows must be >= 0")
+	}
+	for name, r := range map[string]Range{"age": config.Age, "balance": config.Balance, "about words": config.AboutWords} {
+		if r.Min > r.Max {
+			return fmt.Errorf("%s: min %v is more than max %v", name, r.Min, r.Max)
+		}
+		if !validDistribution(r.Distribution) {
+			return fmt.Errorf("%s: unknown distribution %q", name, r.Distribution)
+		}
+	}
+	if config.Age.Min < 0 || config.AboutWords.Min < 0 {
+		return fmt.Errorf("age and about words must be >= 0")
+	}
+	if config.RegisteredFrom.After(config.RegisteredTo) {
+		return fmt.Errorf("registered: from %s is after to %s", config.RegisteredFrom, config.RegisteredTo)
+	}
+	if !validDistribution(config.Registered) {
+		return fmt.Errorf("registered: unknown distribution %q", config.Registered)
+	}
+	for name, rate := range map[string]float64{"name duplicate rate": config.NameDuplicateRate, "about duplicate rate": config.AboutDuplicateRate, "active rate": config.ActiveRate} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("%s must be between 0 and 1", name)
+		}
+	}
+	return nil
+}
+
+func validDistribution(d Distribution) bool {
+	return d == "" || d == Uniform || d == Normal || d == Exponential
+}
+
+// Generator отдаёт записи по одной. Не для одновременного использования из нескольких горутин
+type Generator struct {
+	config Config
+	rng    *rand.Rand
+	next   int
+
+	// выданные раньше имена и описания, из них берутся дубликаты
+	names  pool
+	abouts pool
+}
+
+// poolSize - сколько прежних значений помнить для дубликатов. Хранить все нельзя:
+// на миллионе записей одни About займут сотни мегабайт
+const poolSize = 1024
+
+// pool - случайная выборка выданных значений постоянного размера
+type pool struct {
+	values []string
+	seen   int
+}
+
+// add запоминает value. Когда пул полон, value заменяет случайное из запомненных,
+// чтобы дубликаты брались из всего датасета, а не только из его начала
+func (p *pool) add(rng *rand.Rand, value string) {
+	p.seen++
+	if len(p.values) < poolSize {
+		p.values = append(p.values, value)
+		return
+	}
+	if i := rng.Intn(p.seen); i < poolSize {
+		p.values[i] = value
+	}
+}
+
+func (p *pool) pick(rng *rand.Rand) string {
+	return p.values[rng.Intn(len(p.values))]
+}
+
+// New - генератор по config. Невалидный config - паника, проверять заранее через Validate
+func New(config Config) *Generator {
+	if err := config.Validate(); err != nil {
+		panic("datagen: " + err.Error())
+	}
+	config = config.withDefaults()
+	return &Generator{config: config, rng: rand.New(rand.NewSource(config.Seed))}
+}
+
+// Generate - все config.Rows записей сразу
+func Generate(config Config) []schema.Row {
+	gen := New(config)
+	rows := make([]schema.Row, 0, gen.config.Rows)
+	for {
+		row, ok := gen.Next()
+		if !ok {
+			return rows
+		}
+		rows = append(rows, row)
+	}
+}
+
+// Next - следующая запись, false - записи кончились. Id идут подряд с нуля, как в dataset.xml
+func (gen *Generator) Next() (schema.Row, bool) {
+	if gen.next >= gen.config.Rows {
+		return schema.Row{}, false
+	}
+	id := gen.next
+	gen.next++
+	rng := gen.rng
+
+	var gender, first, last string
+	if len(gen.names.values) > 0 && gen.hit(gen.config.NameDuplicateRate) {
+		name := strings.Fields(gen.names.pick(rng))
+		gender, first, last = name[0], name[1], name[2]
+	} else {
+		gender = []string{"male", "female"}[rng.Intn(2)]
+		if gender == "male" {
+			first = pick(rng, maleNames)
+		} else {
+			first = pick(rng, femaleNames)
+		}
+		last = pick(rng, lastNames)
+		if gen.config.NameDuplicateRate > 0 {
+			gen.names.add(rng, gender+" "+first+" "+last)
+		}
+	}
+
+	var about string
+	if len(gen.abouts.values) > 0 && gen.hit(gen.config.AboutDuplicateRate) {
+		about = gen.abouts.pick(rng)
+	} else {
+		about = gen.about()
+		if gen.config.AboutDuplicateRate > 0 {
+			gen.abouts.add(rng, about)
+		}
+	}
+
+	company := pick(rng, companies)
+	row := schema.Row{
+		Id:            id,
+		Guid:          gen.guid(),
+		IsActive:      fmt.Sprint(gen.hit(gen.config.ActiveRate)),
+		Balance:       formatBalance(gen.value(gen.config.Balance)),
+		Picture:       "http://placehold.it/32x32",
+		Age:           int(math.Round(gen.value(gen.config.Age))),
+		EyeColor:      pick(rng, eyeColors),
+		FirstName:     first,
+		LastName:      last,
+		Gender:        gender,
+		Company:       company,
+		Email:         strings.ToLower(first+last) + "@" + strings.ToLower(company) + ".com",
+		Phone:         fmt.Sprintf("+1 (%03d) %03d-%04d", 800+rng.Intn(200), rng.Intn(1000), rng.Intn(10000)),
+		Address:       fmt.Sprintf("%d %s, %s, %s, %d", 100+rng.Intn(900), pick(rng, streets), pick(rng, cities), pick(rng, states), 1000+rng.Intn(9000)),
+		About:         about,
+		Registered:    gen.registered(),
+		FavoriteFruit: pick(rng, fruits),
+	}
+	return row, true
+}
+
+// hit - бросок с вероятностью rate. Нулевая доля не тратит бросок, чтобы включение дубликатов
+// не меняло остальные поля записей
+func (gen *Generator) hit(rate float64) bool {
+	return rate > 0 && gen.rng.Float64() < rate
+}
+
+// value - случайное значение из r
+func (gen *Generator) value(r Range) float64 {
+	return r.Min + (r.Max-r.Min)*gen.unit(r.Distribution)
+}
+
+// unit - случайное число от 0 до 1 с распределением d
+func (gen *Generator) unit(d Distribution) float64 {
+	switch d {
+	case Normal:
+		return clamp(0.5 + gen.rng.NormFloat64()/6)
+	case Exponential:
+		//среднее - пятая часть диапазона
+		return clamp(gen.rng.ExpFloat64() / 5)
+	default:
+		return gen.rng.Float64()
+	}
+}
+
+func clamp(x float64) float64 {
+	return math.Max(0, math.Min(1, x))
+}
+
+func (gen *Generator) registered() string {
+	from, to := gen.config.RegisteredFrom, gen.config.RegisteredTo
+	at := from.Add(time.Duration(float64(to.Sub(from)) * gen.unit(gen.config.Registered)))
+	//часовой пояс как у dataset.xml: от -12 до +12 часов
+	zone := time.FixedZone("", (gen.rng.Intn(25)-12)*3600)
+	return at.In(zone).Format("2006-01-02T15:04:05 -07:00")
+}
+
+// about - предложения из lorem ipsum
+func (gen *Generator) about() string {
+	words := int(math.Round(gen.value(gen.config.AboutWords)))
+	text := &strings.Builder{}
+	sentence := 0
+	for i := 0; i < words; i++ {
+		word := pick(gen.rng, loremWords)
+		if sentence == 0 {
+			word = strings.ToUpper(word[:1]) + word[1:]
+			if i > 0 {
+				text.WriteString(" ")
+			}
+		} else {
+			text.WriteString(" ")
+		}
+		text.WriteString(word)
+		sentence++
+		if sentence >= 4+gen.rng.Intn(10) || i == words-1 {
+			text.WriteString(".")
+			sentence = 0
+		}
+	}
+	return text.String()
+}
+
+func (gen *Generator) guid() string {
+	b := make([]byte, 16)
+	gen.rng.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// formatBalance - сумма в виде $2,144.93
+func formatBalance(amount float64) string {
+	cents := int64(math.Round(amount * 100))
+	digits := fmt.Sprint(cents / 100)
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
+	}
+	return fmt.Sprintf("$%s.%02d", digits, cents%100)
+}
+
+func pick(rng *rand.Rand, values []string) string {
+	return values[rng.Intn(len(values))]
+}
+
+var (
+	maleNames   = []string{"Boyd", "Brooks", "Owen", "Gonzalez", "Cohen", "Whitley", "Leann", "Dillard", "Glenn", "Jennings", "Henderson", "Johns", "Gates", "Nicholson", "Beasley", "Christy"}
+	femaleNames = []string{"Hilda", "Rebekah", "Rose", "Annie", "Palmer", "Nicole", "Twila", "Allison", "Cruz", "Everett", "Kane", "Terrell", "Gilmore", "Lowery", "Jimenez", "Wendi"}
+	lastNames   = []string{"Wolf", "Mayer", "Sims", "Carr", "Osborn", "Knowles", "Snow", "Holt", "Mcbride", "Hines", "Woodward", "Shaffer", "Valenzuela", "Dillard", "Silva", "Clay", "Ayala", "Cash", "Forbes", "Garrett"}
+	companies   = []string{"HOPELI", "DIGIGEN", "TALAE", "POLARAX", "EARTHWAX", "ZOLARITY", "GEEKOLOGY", "ISOTRONIC", "QUONATA", "BOILICON", "XYLAR", "MEDESIGN"}
+	eyeColors   = []string{"blue", "brown", "green"}
+	fruits      = []string{"apple", "banana", "strawberry"}
+	streets     = []string{"Winthrop Street", "Coleridge Street", "Henderson Walk", "Harbor Lane", "Willoughby Avenue", "Fountain Avenue", "Bokee Court", "Montague Terrace"}
+	cities      = []string{"Edneyville", "Sisquoc", "Trucksville", "Herlong", "Bowmansville", "Snowville", "Blanco", "Wyoming"}
+	states      = []string{"Mississippi", "Virginia", "Utah", "Oregon", "Kansas", "Nevada", "Idaho", "Maine"}
+	loremWords  = []string{"lorem", "ipsum", "dolor", "sit", "amet", "consectetur", "adipisicing", "elit", "sed", "do", "eiusmod", "tempor", "incididunt", "ut", "labore", "et", "dolore", "magna", "aliqua", "enim", "ad", "minim", "veniam", "quis", "nostrud", "exercitation", "ullamco", "laboris", "nisi", "aliquip", "ex", "ea", "commodo", "consequat", "duis", "aute", "irure", "in", "reprehenderit", "voluptate", "velit", "esse", "cillum", "fugiat", "nulla", "pariatur", "excepteur", "sint", "occaecat", "cupidatat", "non", "proident", "sunt", "culpa", "qui", "officia", "deserunt", "mollit", "anim", "id", "est", "laborum"}
+)
@@ -0,0 +1,185 @@
+package datagen
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeterministic(t *testing.T) {
+	config := Config{Seed: 7, Rows: 200, NameDuplicateRate: 0.3, AboutDuplicateRate: 0.3, ActiveRate: 0.5}
+	first, second := Generate(config), Generate(config)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("test failed - same seed gave different rows")
+	}
+	config.Seed = 8
+	if reflect.DeepEqual(first, Generate(config)) {
+		t.Errorf("test failed - different seeds gave same rows")
+	}
+	for i, row := range first {
+		if row.Id != i {
+			t.Fatalf("test failed - row %d has id %d", i, row.Id)
+		}
+	}
+}
+
+func parseBalance(t *testing.T, balance string) float64 {
+	value, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimPrefix(balance, "$"), ",", ""), 64)
+	if err != nil {
+		t.Fatalf("test failed - bad balance %q", balance)
+	}
+	return value
+}
+
+func TestRanges(t *testing.T) {
+	config := Config{
+		Seed:           1,
+		Rows:           2000,
+		Age:            Range{Min: 18, Max: 30, Distribution: Normal},
+		Balance:        Range{Min: 10, Max: 5000000, Distribution: Exponential},
+		RegisteredFrom: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		RegisteredTo:   time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC),
+		AboutWords:     Range{Min: 3, Max: 10},
+	}
+	for _, row := range Generate(config) {
+		if row.Age < 18 || row.Age > 30 {
+			t.Errorf("test failed - age %d out of range", row.Age)
+		}
+		if balance := parseBalance(t, row.Balance); balance < 10 || balance > 5000000 {
+			t.Errorf("test failed - balance %s out of range", row.Balance)
+		}
+		registered, err := time.Parse("2006-01-02T15:04:05 -07:00", row.Registered)
+		if err != nil || registered.Before(config.RegisteredFrom) || registered.After(config.RegisteredTo) {
+			t.Errorf("test failed - registered %s out of range: %v", row.Registered, err)
+		}
+		if words := len(strings.Fields(row.About)); words < 3 || words > 10 {
+			t.Errorf("test failed - %d words in about", words)
+		}
+		if row.IsActive != "false" {
+			t.Errorf("test failed - zero ActiveRate must give no active users")
+		}
+	}
+}
+
+func TestDistributions(t *testing.T) {
+	mean := func(d Distribution) (float64, float64) {
+		rows := Generate(Config{Seed: 3, Rows: 5000, Age: Range{Min: 0, Max: 100, Distribution: d}})
+		sum, middle := 0.0, 0
+		for _, row := range rows {
+			sum += float64(row.Age)
+			if row.Age > 33 && row.Age < 67 {
+				middle++
+			}
+		}
+		return sum / float64(len(rows)), float64(middle) / float64(len(rows))
+	}
+
+	uniformMean, uniformMiddle := mean(Uniform)
+	normalMean, normalMiddle := mean(Normal)
+	exponentialMean, _ := mean(Exponential)
+	if math.Abs(uniformMean-50) > 3 || math.Abs(normalMean-50) > 3 {
+		t.Errorf("test failed - uniform and normal must center at 50, got %.1f and %.1f", uniformMean, normalMean)
+	}
+	//в средней трети равномерного - треть значений, нормального с тремя сигмами до краёв - около 68%
+	if math.Abs(uniformMiddle-0.33) > 0.03 || math.Abs(normalMiddle-0.68) > 0.03 {
+		t.Errorf("test failed - middle third: uniform %.2f, normal %.2f", uniformMiddle, normalMiddle)
+	}
+	if math.Abs(exponentialMean-20) > 3 {
+		t.Errorf("test failed - exponential mean must be near 20, got %.1f", exponentialMean)
+	}
+}
+
+func TestDuplicateRates(t *testing.T) {
+	rows := Generate(Config{Seed: 5, Rows: 4000, AboutDuplicateRate: 0.25, NameDuplicateRate: 1})
+	abouts := map[string]bool{}
+	duplicates := 0
+	for _, row := range rows {
+		if abouts[row.About] {
+			duplicates++
+		}
+		abouts[row.About] = true
+		if row.FirstName != rows[0].FirstName || row.LastName != rows[0].LastName || row.Gender != rows[0].Gender {
+			t.Fatalf("test failed - NameDuplicateRate 1 must repeat the first name, got %+v", row)
+		}
+	}
+	//About из десятков слов сами по себе не совпадают, так что повторы - только заданные
+	if rate := float64(duplicates) / float64(len(rows)); math.Abs(rate-0.25) > 0.03 {
+		t.Errorf("test failed - about duplicate rate %.3f, expected 0.25", rate)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []Config{
+		{Rows: -1},
+		{Age: Range{Min: 50, Max: 10}},
+		{Balance: Range{Min: 1, Max: 2, Distribution: "zipf"}},
+		{RegisteredFrom: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), RegisteredTo: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{NameDuplicateRate: 1.5},
+		{ActiveRate: -0.1},
+	}
+	for _, config := range cases {
+		if config.Validate() == nil {
+			t.Errorf("test failed - %+v must be invalid", config)
+		}
+	}
+	if err := DefaultConfig.Validate(); err != nil {
+		t.Errorf("test failed - default config: %s", err)
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	rows := Generate(Config{Seed: 9, Rows: 50, ActiveRate: 0.5})
+	for _, format := range Formats {
+		data := &bytes.Buffer{}
+		writer, err := NewWriter(data, format)
+		if err != nil {
+			t.Fatalf("test failed - %s", err)
+		}
+		for _, row := range rows {
+			if err = writer.Write(row); err != nil {
+				t.Fatalf("test failed - %s", err)
+			}
+		}
+		if err = writer.Close(); err != nil {
+			t.Fatalf("test failed - %s", err)
+		}
+
+		got, err := Read(data, format)
+		if err != nil || !reflect.DeepEqual(got, rows) {
+			t.Errorf("%s: test failed - rows differ after write and read: %v", format, err)
+		}
+	}
+
+	if _, err := NewWriter(&bytes.Buffer{}, "csv"); err == nil {
+		t.Errorf("test failed - csv is not supported")
+	}
+}
+
+func TestWriteFileReadFile(t *testing.T) {
+	rows := Generate(Config{Seed: 9, Rows: 10})
+	for _, name := range []string{"users.xml", "users.json"} {
+		path := t.TempDir() + "/" + name
+		if err := WriteFile(path, rows); err != nil {
+			t.Fatalf("test failed - %s", err)
+		}
+		got, err := ReadFile(path)
+		if err != nil || !reflect.DeepEqual(got, rows) {
+			t.Errorf("%s: test failed - %v", name, err)
+		}
+	}
+	if err := WriteFile(t.TempDir()+"/users.txt", rows); err == nil {
+		t.Errorf("test failed - unknown extension must fail")
+	}
+}
+
+// TestReadDatasetXML - генерированные файлы читаются тем же кодом, что и настоящий dataset.xml
+func TestReadDatasetXML(t *testing.T) {
+	rows, err := ReadFile("../dataset.xml")
+	if err != nil || len(rows) == 0 || rows[1].FirstName != "Hilda" {
+		t.Errorf("test failed - %d rows, %v", len(rows), err)
+	}
+}
@@ -0,0 +1,170 @@
+package datagen
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"lesson4/schema"
+)
+
+// Format - формат файла датасета
+type Format string
+
+const (
+	// FormatXML - как dataset.xml: <root> со списком <row>
+	FormatXML Format = "xml"
+	// FormatJSON - json-массив записей с теми же именами полей, что и в xml
+	FormatJSON Format = "json"
+)
+
+// Formats - все поддерживаемые форматы
+var Formats = []Format{FormatXML, FormatJSON}
+
+// ParseFormat - формат по имени
+func ParseFormat(name string) (Format, error) {
+	for _, format := range Formats {
+		if string(format) == strings.ToLower(name) {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown dataset format %q", name)
+}
+
+// FormatFromPath - формат по расширению файла
+func FormatFromPath(path string) (Format, error) {
+	return ParseFormat(strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+// Writer пишет записи по одной, не собирая датасет в памяти. После последней записи нужен Close
+type Writer struct {
+	format  Format
+	w       *bufio.Writer
+	xml     *xml.Encoder
+	written int
+	err     error
+}
+
+// NewWriter начинает датасет в формате format
+func NewWriter(w io.Writer, format Format) (*Writer, error) {
+	writer := &Writer{format: format, w: bufio.NewWriter(w)}
+	switch format {
+	case FormatXML:
+		writer.xml = xml.NewEncoder(writer.w)
+		writer.xml.Indent("  ", "  ")
+		_, writer.err = writer.w.WriteString(`<?xml version="1.0" encoding="UTF-8" ?>` + "\n<root>\n")
+	case FormatJSON:
+		_, writer.err = writer.w.WriteString("[")
+	default:
+		return nil, fmt.Errorf("unknown dataset format %q", format)
+	}
+	return writer, writer.err
+}
+
+// Write дописывает одну запись. После первой ошибки все вызовы возвращают её же
+func (writer *Writer) Write(row schema.Row) error {
+	if writer.err != nil {
+		return writer.err
+	}
+	switch writer.format {
+	case FormatXML:
+		writer.err = writer.xml.EncodeElement(row, xml.StartElement{Name: xml.Name{Local: "row"}})
+	case FormatJSON:
+		var data []byte
+		if data, writer.err = json.Marshal(row); writer.err != nil {
+			return writer.err
+		}
+		if writer.written > 0 {
+			writer.w.WriteString(",")
+		}
+		writer.w.WriteString("\n  ")
+		_, writer.err = writer.w.Write(data)
+	}
+	writer.written++
+	return writer.err
+}
+
+// Close закрывает корневой элемент и сбрасывает буфер. Сам w не закрывает
+func (writer *Writer) Close() error {
+	if writer.err != nil {
+		return writer.err
+	}
+	switch writer.format {
+	case FormatXML:
+		if writer.err = writer.xml.Flush(); writer.err != nil {
+			return writer.err
+		}
+		if writer.written > 0 {
+			writer.w.WriteString("\n")
+		}
+		writer.w.WriteString("</root>\n")
+	case FormatJSON:
+		writer.w.WriteString("\n]\n")
+	}
+	writer.err = writer.w.Flush()
+	return writer.err
+}
+
+// WriteFile пишет rows в path в формате по расширению файла
+func WriteFile(path string, rows []schema.Row) error {
+	format, err := FormatFromPath(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	writer, err := NewWriter(file, format)
+	for _, row := range rows {
+		if err != nil {
+			break
+		}
+		err = writer.Write(row)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Read читает весь датасет в формате format
+func Read(r io.Reader, format Format) ([]schema.Row, error) {
+	switch format {
+	case FormatXML:
+		root := schema.Root{}
+		if err := xml.NewDecoder(r).Decode(&root); err != nil {
+			return nil, err
+		}
+		return root.Rows, nil
+	case FormatJSON:
+		rows := []schema.Row{}
+		if err := json.NewDecoder(r).Decode(&rows); err != nil {
+			return nil, err
+		}
+		return rows, nil
+	}
+	return nil, fmt.Errorf("unknown dataset format %q", format)
+}
+
+// ReadFile читает датасет из path в формате по расширению файла
+func ReadFile(path string) ([]schema.Row, error) {
+	format, err := FormatFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Read(bufio.NewReader(file), format)
+}
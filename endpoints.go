@@ -0,0 +1,293 @@
+package lesson4
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoEndpoints - в EndpointPool нет ни одной реплики, запрос отправить некуда
+var ErrNoEndpoints = errors.New("endpoint pool is empty")
+
+// как EndpointPool выбирает реплику для запроса
+type Selection int
+
+const (
+	// по кругу
+	SelectRoundRobin Selection = iota
+	// ту, что в последнее время отвечала быстрее всех
+	SelectLeastLatency
+)
+
+// сколько последних времён ответа помнит пул для выбора задержки перед дублирующим запросом
+const latencyWindow = 100
+
+// EndpointPool - несколько реплик внешней системы. Реплика, которая подряд EjectAfter раз
+// не ответила или ответила 5xx, выводится из выбора на EjectFor. Если выведены все,
+// запрос идёт в ту, что вернётся раньше других, - лучше попробовать, чем сразу отказать.
+//
+// С HedgeQuantile > 0 пул дублирует запрос в другую реплику, если первая не ответила
+// за этот квантиль последних времён ответа, и берёт тот ответ, что пришёл первым
+type EndpointPool struct {
+	Selection Selection
+	// сколько неудач подряд выводят реплику из выбора, 0 - не выводить
+	EjectAfter int
+	EjectFor   time.Duration
+	// квантиль времени ответа, после которого отправляется дублирующий запрос, например 0.95. 0 - не дублировать
+	HedgeQuantile float64
+	// сколько ответов нужно накопить, прежде чем начать дублировать
+	HedgeMinSamples int
+
+	endpoints []*endpoint
+
+	mu        sync.Mutex
+	next      int
+	latencies []time.Duration
+	// часы, подменяются в тестах
+	now func() time.Time
+}
+
+type endpoint struct {
+	url string
+	// сглаженное время ответа, 0 - реплика ещё не отвечала
+	latency      time.Duration
+	failures     int
+	ejectedUntil time.Time
+}
+
+// NewEndpointPool - пул по списку адресов реплик, каждый в том же виде, что SearchClient.URL.
+// Пул без адресов на каждый запрос возвращает ErrNoEndpoints
+func NewEndpointPool(urls ...string) *EndpointPool {
+	pool := &EndpointPool{EjectAfter: 3, EjectFor: 10 * time.Second, HedgeMinSamples: 20}
+	for _, u := range urls {
+		pool.endpoints = append(pool.endpoints, &endpoint{url: strings.TrimSuffix(u, "/")})
+	}
+	return pool
+}
+
+// Healthy - адреса реплик, которые сейчас участвуют в выборе
+func (p *EndpointPool) Healthy() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	healthy := []string{}
+	for _, e := range p.endpoints {
+		if !p.ejected(e) {
+			healthy = append(healthy, e.url)
+		}
+	}
+	return healthy
+}
+
+// do отправляет запрос в выбранную реплику. Путь и параметры берутся из req.URL без base
+func (p *EndpointPool) do(httpClient *http.Client, req *http.Request, base string) (*http.Response, error) {
+	if len(p.endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+	path := strings.TrimPrefix(req.URL.String(), base)
+	primary := p.pick(nil)
+
+	delay, hedge := p.hedgeDelay()
+	if !hedge || len(p.endpoints) < 2 {
+		return p.attempt(req.Context(), httpClient, primary, req, path)
+	}
+
+	type result struct {
+		resp *http.Response
+		err  error
+		idx  int
+	}
+	results := make(chan result, 2)
+	cancels := []context.CancelFunc{}
+	launch := func(e *endpoint) {
+		ctx, cancel := context.WithCancel(req.Context())
+		idx := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			resp, err := p.attempt(ctx, httpClient, e, req, path)
+			results <- result{resp, err, idx}
+		}()
+	}
+	drop := func(r result) {
+		if r.resp != nil {
+			r.resp.Body.Close()
+		}
+		cancels[r.idx]()
+	}
+
+	launch(primary)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	hedgeC := timer.C
+	pending := 1
+	for {
+		select {
+		case <-hedgeC:
+			hedgeC = nil
+			launch(p.pick(primary))
+			pending++
+		case r := <-results:
+			pending--
+			failed := r.err != nil || r.resp.StatusCode >= http.StatusInternalServerError
+			if failed && pending > 0 {
+				//неудачный ответ отдадим, только если второй тоже не удастся
+				drop(r)
+				continue
+			}
+
+			for i, cancel := range cancels {
+				if i != r.idx {
+					cancel()
+				}
+			}
+			if pending > 0 {
+				//проигравший запрос отменён, его ответ закрываем, когда он придёт
+				go func() { drop(<-results) }()
+			}
+			if r.resp == nil {
+				cancels[r.idx]()
+				return nil, r.err
+			}
+			r.resp.Body = &cancelOnClose{r.resp.Body, cancels[r.idx]}
+			return r.resp, nil
+		}
+	}
+}
+
+// attempt - один запрос в реплику e с учётом его результата в её здоровье и времени ответа
+func (p *EndpointPool) attempt(ctx context.Context, httpClient *http.Client, e *endpoint, req *http.Request, path string) (*http.Response, error) {
+	target, err := url.Parse(e.url + path)
+	if err != nil {
+		return nil, err
+	}
+	out := req.Clone(ctx)
+	out.URL = target
+	out.Host = ""
+	if req.GetBody != nil {
+		if out.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	started := p.clock()
+	resp, err := httpClient.Do(out)
+	if ctx.Err() == context.Canceled && req.Context().Err() == nil {
+		//запрос отменили мы сами, потому что другая реплика ответила раньше
+		return resp, err
+	}
+	p.observe(e, p.clock().Sub(started), err != nil || resp.StatusCode >= http.StatusInternalServerError)
+	return resp, err
+}
+
+// pick выбирает реплику, по возможности не except
+func (p *EndpointPool) pick(except *endpoint) *endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	candidates := []*endpoint{}
+	for _, e := range p.endpoints {
+		if e != except && !p.ejected(e) {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, e := range p.endpoints {
+			if e != except {
+				candidates = append(candidates, e)
+			}
+		}
+		if len(candidates) == 0 {
+			return except
+		}
+		//выведены все, берём ту, что вернётся раньше других
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].ejectedUntil.Before(candidates[j].ejectedUntil)
+		})
+		return candidates[0]
+	}
+
+	if p.Selection == SelectLeastLatency {
+		best := candidates[0]
+		for _, e := range candidates[1:] {
+			if e.latency < best.latency {
+				best = e
+			}
+		}
+		return best
+	}
+	e := candidates[p.next%len(candidates)]
+	p.next++
+	return e
+}
+
+// observe учитывает ответ реплики e
+func (p *EndpointPool) observe(e *endpoint, latency time.Duration, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if failed {
+		e.failures++
+		if p.EjectAfter > 0 && e.failures >= p.EjectAfter {
+			e.ejectedUntil = p.clock().Add(p.EjectFor)
+			e.failures = 0
+		}
+		return
+	}
+	e.failures = 0
+	if e.latency == 0 {
+		e.latency = latency
+	} else {
+		e.latency = (e.latency*4 + latency) / 5
+	}
+	p.latencies = append(p.latencies, latency)
+	if len(p.latencies) > latencyWindow {
+		p.latencies = p.latencies[len(p.latencies)-latencyWindow:]
+	}
+}
+
+// hedgeDelay - сколько ждать ответа, прежде чем дублировать запрос, и нужно ли дублировать вообще
+func (p *EndpointPool) hedgeDelay() (time.Duration, bool) {
+	if p.HedgeQuantile <= 0 {
+		return 0, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.latencies) == 0 || len(p.latencies) < p.HedgeMinSamples {
+		return 0, false
+	}
+	sorted := append([]time.Duration(nil), p.latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(p.HedgeQuantile * float64(len(sorted)))
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx], true
+}
+
+func (p *EndpointPool) ejected(e *endpoint) bool {
+	return p.clock().Before(e.ejectedUntil)
+}
+
+func (p *EndpointPool) clock() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// cancelOnClose отменяет контекст запроса, когда закрывают тело ответа
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
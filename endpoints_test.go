@@ -0,0 +1,177 @@
+package lesson4
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// replica - тестовая реплика, которая считает запросы и может тормозить или падать
+type replica struct {
+	*httptest.Server
+	hits    int32
+	delay   time.Duration
+	failing int32
+}
+
+func newReplica(delay time.Duration) *replica {
+	rep := &replica{delay: delay}
+	rep.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&rep.hits, 1)
+		if atomic.LoadInt32(&rep.failing) == 1 {
+			writeSearchError(w, r, errUnavailable)
+			return
+		}
+		select {
+		case <-time.After(rep.delay):
+		case <-r.Context().Done():
+			return
+		}
+		searchMux().ServeHTTP(w, r)
+	}))
+	return rep
+}
+
+func (rep *replica) Hits() int32 { return atomic.LoadInt32(&rep.hits) }
+
+func TestEndpointsRoundRobin(t *testing.T) {
+	first, second := newReplica(0), newReplica(0)
+	defer first.Close()
+	defer second.Close()
+
+	client := &SearchClient{AccessToken: "TestToken", APIVersion: APIVersionV1, Endpoints: NewEndpointPool(first.URL, second.URL)}
+	for i := 0; i < 4; i++ {
+		result, err := client.FindUsers(SearchRequest{Limit: 1})
+		if err != nil || len(result.Users) != 1 {
+			t.Fatalf("test failed - %v, %v", result, err)
+		}
+	}
+	if _, err := client.GetUser(1); err != nil {
+		t.Errorf("test failed - GetUser through pool: %s", err)
+	}
+	if first.Hits() != 3 || second.Hits() != 2 {
+		t.Errorf("test failed - expected 3 and 2 requests, got %d and %d", first.Hits(), second.Hits())
+	}
+}
+
+func TestEndpointsEjection(t *testing.T) {
+	bad, good := newReplica(0), newReplica(0)
+	defer bad.Close()
+	defer good.Close()
+	atomic.StoreInt32(&bad.failing, 1)
+
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	pool := NewEndpointPool(bad.URL, good.URL)
+	pool.EjectAfter = 2
+	pool.EjectFor = time.Minute
+	pool.now = clock.Now
+	client := &SearchClient{AccessToken: "TestToken", Endpoints: pool}
+
+	for i := 0; i < 10; i++ {
+		client.FindUsers(SearchRequest{Limit: 1})
+	}
+	if bad.Hits() != 2 {
+		t.Errorf("test failed - bad replica must be ejected after 2 failures, got %d requests", bad.Hits())
+	}
+	if !reflect.DeepEqual(pool.Healthy(), []string{good.URL}) {
+		t.Errorf("test failed - expected only good replica healthy, got %v", pool.Healthy())
+	}
+
+	atomic.StoreInt32(&bad.failing, 0)
+	clock.now = clock.now.Add(time.Minute)
+	for i := 0; i < 4; i++ {
+		if _, err := client.FindUsers(SearchRequest{Limit: 1}); err != nil {
+			t.Errorf("test failed - %s", err)
+		}
+	}
+	if bad.Hits() != 4 || len(pool.Healthy()) != 2 {
+		t.Errorf("test failed - replica must come back after EjectFor, got %d requests, healthy %v", bad.Hits(), pool.Healthy())
+	}
+}
+
+func TestEndpointsAllEjected(t *testing.T) {
+	bad := newReplica(0)
+	defer bad.Close()
+	atomic.StoreInt32(&bad.failing, 1)
+
+	pool := NewEndpointPool(bad.URL)
+	pool.EjectAfter = 1
+	client := &SearchClient{AccessToken: "TestToken", Endpoints: pool}
+	client.FindUsers(SearchRequest{Limit: 1})
+	client.FindUsers(SearchRequest{Limit: 1})
+	if bad.Hits() != 2 {
+		t.Errorf("test failed - with all replicas ejected requests must still go out, got %d", bad.Hits())
+	}
+}
+
+func TestEndpointsEmptyPool(t *testing.T) {
+	client := &SearchClient{AccessToken: "TestToken", Endpoints: NewEndpointPool()}
+	if _, err := client.FindUsers(SearchRequest{Limit: 1}); !errors.Is(err, ErrNoEndpoints) {
+		t.Errorf("test failed - expected ErrNoEndpoints, got %v", err)
+	}
+	if _, err := client.GetUser(1); !errors.Is(err, ErrNoEndpoints) {
+		t.Errorf("test failed - GetUser: expected ErrNoEndpoints, got %v", err)
+	}
+}
+
+func TestEndpointsLeastLatency(t *testing.T) {
+	slow, fast := newReplica(30*time.Millisecond), newReplica(0)
+	defer slow.Close()
+	defer fast.Close()
+
+	pool := NewEndpointPool(slow.URL, fast.URL)
+	pool.Selection = SelectLeastLatency
+	client := &SearchClient{AccessToken: "TestToken", Endpoints: pool}
+	for i := 0; i < 6; i++ {
+		if _, err := client.FindUsers(SearchRequest{Limit: 1}); err != nil {
+			t.Fatalf("test failed - %s", err)
+		}
+	}
+	if slow.Hits() != 1 || fast.Hits() != 5 {
+		t.Errorf("test failed - expected 1 request to slow and 5 to fast, got %d and %d", slow.Hits(), fast.Hits())
+	}
+}
+
+func TestEndpointsHedging(t *testing.T) {
+	slow, fast := newReplica(500*time.Millisecond), newReplica(0)
+	defer slow.Close()
+	defer fast.Close()
+
+	pool := NewEndpointPool(slow.URL, fast.URL)
+	pool.HedgeQuantile = 0.9
+	pool.HedgeMinSamples = 1
+	pool.latencies = []time.Duration{10 * time.Millisecond}
+	client := &SearchClient{AccessToken: "TestToken", Endpoints: pool, UseJSONBody: true}
+
+	started := time.Now()
+	result, err := client.FindUsers(SearchRequest{Limit: 2})
+	if err != nil || len(result.Users) != 2 {
+		t.Fatalf("test failed - %v, %v", result, err)
+	}
+	if elapsed := time.Since(started); elapsed > 300*time.Millisecond {
+		t.Errorf("test failed - hedged request must not wait for slow replica, took %s", elapsed)
+	}
+	if slow.Hits() != 1 || fast.Hits() != 1 {
+		t.Errorf("test failed - expected one request to each replica, got %d and %d", slow.Hits(), fast.Hits())
+	}
+}
+
+func TestEndpointsNoHedgeWithoutSamples(t *testing.T) {
+	slow, fast := newReplica(50*time.Millisecond), newReplica(0)
+	defer slow.Close()
+	defer fast.Close()
+
+	pool := NewEndpointPool(slow.URL, fast.URL)
+	pool.HedgeQuantile = 0.9
+	client := &SearchClient{AccessToken: "TestToken", Endpoints: pool}
+	if _, err := client.FindUsers(SearchRequest{Limit: 1}); err != nil {
+		t.Fatalf("test failed - %s", err)
+	}
+	if slow.Hits() != 1 || fast.Hits() != 0 {
+		t.Errorf("test failed - no hedging before HedgeMinSamples, got %d and %d", slow.Hits(), fast.Hits())
+	}
+}
@@ -0,0 +1,293 @@
+// Package fakesearch - поддельная внешняя система поиска для тестов кода, который ходит в неё через SearchClient.
+// Ответы задаются по порядку цепочкой вызовов, полученные запросы запоминаются:
+//
+//	srv := fakesearch.New(t)
+//	srv.Next().Users(user)
+//	srv.Next().Status(http.StatusInternalServerError)
+//	srv.Next().Delay(2 * time.Second)
+//	client := &SearchClient{URL: srv.URL, AccessToken: "token"}
+//	...
+//	srv.AssertRequestCount(3)
+//	srv.AssertQuery("order_field", "Age")
+//
+// Когда заданные ответы кончаются, отвечает Default - по умолчанию пустым списком
+package fakesearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"lesson4/schema"
+)
+
+// Server - поддельная внешняя система. Закрывается сама по окончании теста
+type Server struct {
+	// адрес для SearchClient.URL
+	URL string
+
+	tb       testing.TB
+	server   *httptest.Server
+	mu       sync.Mutex
+	script   []*Response
+	fallback *Response
+	requests []Request
+}
+
+// Request - запрос, который получил Server
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+func New(tb testing.TB) *Server {
+	s := &Server{tb: tb, fallback: newResponse().Users()}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serve))
+	s.URL = s.server.URL
+	tb.Cleanup(s.Close)
+	return s
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Next добавляет ответ на следующий по порядку запрос
+func (s *Server) Next() *Response {
+	resp := newResponse()
+	s.mu.Lock()
+	s.script = append(s.script, resp)
+	s.mu.Unlock()
+	return resp
+}
+
+// Default - ответ на все запросы после заданных через Next
+func (s *Server) Default() *Response {
+	resp := newResponse()
+	s.mu.Lock()
+	s.fallback = resp
+	s.mu.Unlock()
+	return resp
+}
+
+// Requests - полученные запросы в порядке получения
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// LastRequest - последний полученный запрос. Если запросов не было, тест падает
+func (s *Server) LastRequest() Request {
+	s.tb.Helper()
+	requests := s.Requests()
+	if len(requests) == 0 {
+		s.tb.Fatalf("fakesearch: no requests received")
+	}
+	return requests[len(requests)-1]
+}
+
+func (s *Server) AssertRequestCount(expected int) {
+	s.tb.Helper()
+	if got := len(s.Requests()); got != expected {
+		s.tb.Errorf("fakesearch: expected %d requests, got %d", expected, got)
+	}
+}
+
+// AssertQuery проверяет параметр query string последнего запроса
+func (s *Server) AssertQuery(key, expected string) {
+	s.tb.Helper()
+	if got := s.LastRequest().Query.Get(key); got != expected {
+		s.tb.Errorf("fakesearch: expected %s=%q, got %q", key, expected, got)
+	}
+}
+
+// AssertHeader проверяет заголовок последнего запроса
+func (s *Server) AssertHeader(key, expected string) {
+	s.tb.Helper()
+	if got := s.LastRequest().Header.Get(key); got != expected {
+		s.tb.Errorf("fakesearch: expected header %s %q, got %q", key, expected, got)
+	}
+}
+
+// AssertSearchRequest проверяет параметры поиска последнего запроса, откуда бы они ни пришли:
+// из query string или из json-тела
+func (s *Server) AssertSearchRequest(expected schema.SearchRequest) {
+	s.tb.Helper()
+	last := s.LastRequest()
+	got := schema.SearchRequest{}
+	if len(last.Body) > 0 {
+		if err := json.Unmarshal(last.Body, &got); err != nil {
+			s.tb.Errorf("fakesearch: cant unpack request body: %s", err)
+			return
+		}
+	} else {
+		got.Limit, _ = strconv.Atoi(last.Query.Get("limit"))
+		got.Offset, _ = strconv.Atoi(last.Query.Get("offset"))
+		got.Query = last.Query.Get("query")
+		got.OrderField = last.Query.Get("order_field")
+		got.OrderBy, _ = strconv.Atoi(last.Query.Get("order_by"))
+	}
+	if got != expected {
+		s.tb.Errorf("fakesearch: expected request %+v, got %+v", expected, got)
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+		Body:   body,
+	})
+	resp := s.fallback
+	if len(s.script) > 0 {
+		resp, s.script = s.script[0], s.script[1:]
+	}
+	s.mu.Unlock()
+
+	resp.write(w, r)
+}
+
+// Response - заданный ответ. Методы меняют его и возвращают его же, чтобы их можно было сцеплять
+type Response struct {
+	status int
+	header http.Header
+	body   []byte
+	delay  time.Duration
+	drop   bool
+	// сколько байт тела отдать перед обрывом соединения, -1 - всё
+	partial int
+}
+
+func newResponse() *Response {
+	return &Response{status: http.StatusOK, header: http.Header{}, partial: -1}
+}
+
+func (resp *Response) Status(status int) *Response {
+	resp.status = status
+	return resp
+}
+
+func (resp *Response) Header(key, value string) *Response {
+	resp.header.Set(key, value)
+	return resp
+}
+
+// Body - тело ответа как есть, например битый json
+func (resp *Response) Body(body string) *Response {
+	resp.body = []byte(body)
+	return resp
+}
+
+// JSON - тело ответа из v с Content-Type application/json
+func (resp *Response) JSON(v interface{}) *Response {
+	body, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("fakesearch: cant pack json: %s", err))
+	}
+	resp.body = body
+	resp.header.Set("Content-Type", schema.MediaTypeJSON)
+	return resp
+}
+
+// Users - успешный ответ поиска v1 со списком пользователей
+func (resp *Response) Users(users ...schema.User) *Response {
+	if users == nil {
+		users = []schema.User{}
+	}
+	return resp.Status(http.StatusOK).JSON(users)
+}
+
+// Page - успешный ответ поиска v2
+func (resp *Response) Page(nextPage bool, users ...schema.User) *Response {
+	if users == nil {
+		users = []schema.User{}
+	}
+	resp.Status(http.StatusOK).JSON(schema.SearchResponse{Users: users, NextPage: nextPage})
+	resp.header.Set("Content-Type", schema.MediaTypeV2)
+	return resp
+}
+
+// Error - ошибка в формате внешней системы, code - один из schema.Code*
+func (resp *Response) Error(status int, code, message string) *Response {
+	return resp.Status(status).JSON(schema.SearchErrorResponse{Error: code, Message: message})
+}
+
+// Delay - ответить не раньше чем через d. Если клиент отвалится раньше, ответа не будет
+func (resp *Response) Delay(d time.Duration) *Response {
+	resp.delay = d
+	return resp
+}
+
+// DropConnection - закрыть соединение, ничего не ответив
+func (resp *Response) DropConnection() *Response {
+	resp.drop = true
+	return resp
+}
+
+// PartialBody - объявить полную длину тела, отдать только первые n байт и закрыть соединение
+func (resp *Response) PartialBody(n int) *Response {
+	resp.partial = n
+	return resp
+}
+
+func (resp *Response) write(w http.ResponseWriter, r *http.Request) {
+	if resp.delay > 0 {
+		select {
+		case <-time.After(resp.delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if resp.drop {
+		hijack(w)
+		return
+	}
+
+	for key, values := range resp.header {
+		w.Header()[key] = values
+	}
+	body := resp.body
+	if resp.partial >= 0 && resp.partial < len(body) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		body = body[:resp.partial]
+	}
+	w.WriteHeader(resp.status)
+	w.Write(body)
+
+	if resp.partial >= 0 {
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		hijack(w)
+	}
+}
+
+// hijack забирает соединение у http.Server и закрывает его
+func hijack(w http.ResponseWriter) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return
+	}
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	conn.Close()
+}
@@ -0,0 +1,142 @@
+package fakesearch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"lesson4/schema"
+)
+
+// recordingTB запоминает ошибки вместо того, чтобы ронять тест, чтобы проверить сами assert-помощники
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (tb *recordingTB) Helper() {}
+
+func (tb *recordingTB) Errorf(format string, args ...interface{}) {
+	tb.errors = append(tb.errors, fmt.Sprintf(format, args...))
+}
+
+func get(url string) (*http.Response, []byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp, body, err
+}
+
+func TestScriptInOrder(t *testing.T) {
+	srv := New(t)
+	srv.Next().Users(schema.User{Id: 1, Name: "Mayer Hilda"})
+	srv.Next().Error(http.StatusBadRequest, schema.CodeBadOrderField, "OrderFeld Gender invalid")
+	srv.Next().Status(http.StatusInternalServerError).Body("{bad Json}")
+
+	resp, body, _ := get(srv.URL + "?limit=2")
+	users := []schema.User{}
+	json.Unmarshal(body, &users)
+	if resp.StatusCode != http.StatusOK || len(users) != 1 || users[0].Name != "Mayer Hilda" {
+		t.Errorf("test failed - wrong first response %d %s", resp.StatusCode, body)
+	}
+
+	resp, body, _ = get(srv.URL)
+	errResp := schema.SearchErrorResponse{}
+	json.Unmarshal(body, &errResp)
+	if resp.StatusCode != http.StatusBadRequest || errResp.Error != schema.CodeBadOrderField {
+		t.Errorf("test failed - wrong second response %d %s", resp.StatusCode, body)
+	}
+
+	resp, body, _ = get(srv.URL)
+	if resp.StatusCode != http.StatusInternalServerError || string(body) != "{bad Json}" {
+		t.Errorf("test failed - wrong third response %d %s", resp.StatusCode, body)
+	}
+
+	resp, body, _ = get(srv.URL)
+	if resp.StatusCode != http.StatusOK || string(body) != "[]" {
+		t.Errorf("test failed - default must be empty list, got %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	srv := New(t)
+	srv.Default().Page(true, schema.User{Id: 7})
+
+	for i := 0; i < 2; i++ {
+		resp, body, _ := get(srv.URL)
+		if resp.Header.Get("Content-Type") != schema.MediaTypeV2 || !strings.Contains(string(body), `"next_page":true`) {
+			t.Errorf("test failed - wrong default response %s", body)
+		}
+	}
+}
+
+func TestDelay(t *testing.T) {
+	srv := New(t)
+	srv.Next().Delay(50 * time.Millisecond)
+
+	started := time.Now()
+	get(srv.URL)
+	if time.Since(started) < 50*time.Millisecond {
+		t.Errorf("test failed - response must be delayed")
+	}
+}
+
+func TestDropConnection(t *testing.T) {
+	srv := New(t)
+	srv.Next().DropConnection()
+
+	if _, _, err := get(srv.URL); err == nil {
+		t.Errorf("test failed - dropped connection must fail")
+	}
+}
+
+func TestPartialBody(t *testing.T) {
+	srv := New(t)
+	srv.Next().Users(schema.User{Id: 1}, schema.User{Id: 2}).PartialBody(5)
+
+	_, body, err := get(srv.URL)
+	if err == nil || len(body) != 5 {
+		t.Errorf("test failed - expected 5 bytes and error, got %q, %v", body, err)
+	}
+}
+
+func TestRecordedRequests(t *testing.T) {
+	srv := New(t)
+	req, _ := http.NewRequest("POST", srv.URL+"/v1/users/search", strings.NewReader(`{"limit":3,"order_field":"Age","order_by":-1}`))
+	req.Header.Set("AccessToken", "TestToken")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("test failed - %s", err)
+	}
+	resp.Body.Close()
+	get(srv.URL + "/?limit=2&query=Hilda&order_by=1")
+
+	requests := srv.Requests()
+	if len(requests) != 2 || requests[0].Method != "POST" || requests[0].Path != "/v1/users/search" {
+		t.Fatalf("test failed - wrong requests %+v", requests)
+	}
+	if requests[0].Header.Get("AccessToken") != "TestToken" {
+		t.Errorf("test failed - header not recorded")
+	}
+
+	srv.AssertRequestCount(2)
+	srv.AssertQuery("query", "Hilda")
+	srv.AssertSearchRequest(schema.SearchRequest{Limit: 2, Query: "Hilda", OrderBy: 1})
+
+	tb := &recordingTB{TB: t}
+	srv.tb = tb
+	srv.AssertRequestCount(3)
+	srv.AssertQuery("query", "Mayer")
+	srv.AssertHeader("AccessToken", "TestToken")
+	srv.AssertSearchRequest(schema.SearchRequest{Limit: 3})
+	if len(tb.errors) != 4 {
+		t.Errorf("test failed - expected 4 assertion errors, got %v", tb.errors)
+	}
+}
@@ -0,0 +1,236 @@
+// Package faultinject - RoundTripper, который портит обмен с внешней системой: задерживает запросы,
+// рвёт соединения, обрезает и замедляет тела, подменяет Content-Type и отвечает 5xx.
+// Какие запросы испортить, решает генератор случайных чисел с заданным Seed,
+// поэтому при последовательных запросах прогон повторяется один в один:
+//
+//	chaos := faultinject.New(faultinject.Config{Seed: 1, ResetRate: 0.1, ServerErrorRate: 0.1}, nil)
+//	client := &SearchClient{URL: url, HTTPClient: &http.Client{Transport: chaos, Timeout: time.Second}}
+package faultinject
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInjectedReset - соединение «сброшено» до ответа
+var ErrInjectedReset = errors.New("faultinject: connection reset by peer")
+
+// Config - доли запросов от 0 до 1, которые получают каждую из неисправностей.
+// Задержка и неисправности тела могут сложиться с другими, сброс и 5xx заменяют поход в систему
+type Config struct {
+	Seed int64
+
+	// задержка перед отправкой, случайная от 0 до Latency
+	LatencyRate float64
+	Latency     time.Duration
+
+	ResetRate       float64
+	ServerErrorRate float64
+
+	// тело обрывается на случайном байте
+	TruncateRate float64
+	// ответ как от промежуточного прокси: text/html вместо json
+	WrongContentTypeRate float64
+	// тело отдаётся кусками по SlowBodyChunk байт с паузой SlowBodyDelay
+	SlowBodyRate  float64
+	SlowBodyDelay time.Duration
+	SlowBodyChunk int
+}
+
+// Stats - сколько раз сработала каждая неисправность
+type Stats struct {
+	Requests         int
+	Latency          int
+	Resets           int
+	ServerErrors     int
+	Truncated        int
+	WrongContentType int
+	SlowBody         int
+}
+
+// Transport портит запросы к next по Config
+type Transport struct {
+	config Config
+	next   http.RoundTripper
+
+	mu    sync.Mutex
+	rng   *rand.Rand
+	stats Stats
+}
+
+// New - портящий транспорт поверх next, nil - http.DefaultTransport
+func New(config Config, next http.RoundTripper) *Transport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if config.SlowBodyChunk <= 0 {
+		config.SlowBodyChunk = 16
+	}
+	return &Transport{config: config, next: next, rng: rand.New(rand.NewSource(config.Seed))}
+}
+
+func (t *Transport) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
+
+// plan - неисправности для одного запроса, разыгранные заранее, чтобы порядок бросков не зависел от сети
+type plan struct {
+	latency     time.Duration
+	reset       bool
+	serverError int
+	truncate    bool
+	// какая доля тела дойдёт до клиента при обрыве
+	keep      float64
+	wrongType bool
+	slowBody  bool
+}
+
+func (t *Transport) roll() plan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p := plan{}
+	t.stats.Requests++
+	if t.hit(t.config.LatencyRate) {
+		p.latency = time.Duration(t.rng.Int63n(int64(t.config.Latency) + 1))
+		t.stats.Latency++
+	}
+	switch {
+	case t.hit(t.config.ResetRate):
+		p.reset = true
+		t.stats.Resets++
+		return p
+	case t.hit(t.config.ServerErrorRate):
+		p.serverError = []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable}[t.rng.Intn(3)]
+		t.stats.ServerErrors++
+		return p
+	}
+	//с телом портим что-то одно, иначе не понять, какая неисправность к чему привела
+	switch {
+	case t.hit(t.config.TruncateRate):
+		p.truncate = true
+		p.keep = t.rng.Float64()
+		t.stats.Truncated++
+	case t.hit(t.config.WrongContentTypeRate):
+		p.wrongType = true
+		t.stats.WrongContentType++
+	case t.hit(t.config.SlowBodyRate):
+		p.slowBody = true
+		t.stats.SlowBody++
+	}
+	return p
+}
+
+// hit - бросок с вероятностью rate. Нулевая доля не тратит бросок, чтобы включение одной
+// неисправности не меняло, какие запросы получают остальные
+func (t *Transport) hit(rate float64) bool {
+	return rate > 0 && t.rng.Float64() < rate
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p := t.roll()
+
+	if p.latency > 0 {
+		select {
+		case <-time.After(p.latency):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	if p.reset {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, ErrInjectedReset
+	}
+	if p.serverError != 0 {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return response(req, p.serverError, "text/plain", http.StatusText(p.serverError)), nil
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.truncate:
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = &truncatedBody{data: body[:int(float64(len(body))*p.keep)]}
+	case p.wrongType:
+		resp.Body.Close()
+		return response(req, resp.StatusCode, "text/html", "<html><body><h1>Service Temporarily Unavailable</h1></body></html>"), nil
+	case p.slowBody:
+		resp.Body = &slowBody{body: resp.Body, delay: t.config.SlowBodyDelay, chunk: t.config.SlowBodyChunk, done: req.Context().Done()}
+	}
+	return resp, nil
+}
+
+func response(req *http.Request, status int, contentType, body string) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {contentType}},
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// truncatedBody отдаёт начало тела и обрывается, как при разрыве соединения
+type truncatedBody struct {
+	data []byte
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if len(b.data) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+	return n, nil
+}
+
+func (b *truncatedBody) Close() error { return nil }
+
+// slowBody отдаёт тело кусками с паузами. Пауза прерывается, когда запрос отменяют
+type slowBody struct {
+	body  io.ReadCloser
+	delay time.Duration
+	chunk int
+	done  <-chan struct{}
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	select {
+	case <-time.After(b.delay):
+	case <-b.done:
+		return 0, errSlowBodyCanceled
+	}
+	if len(p) > b.chunk {
+		p = p[:b.chunk]
+	}
+	return b.body.Read(p)
+}
+
+func (b *slowBody) Close() error { return b.body.Close() }
+
+var errSlowBodyCanceled = errors.New("faultinject: request canceled while reading slow body")
@@ -0,0 +1,134 @@
+package faultinject
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const payload = `[{"id":1,"name":"Mayer Hilda"},{"id":2,"name":"Wolf Boyd"}]`
+
+func newServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, payload)
+	}))
+}
+
+func fetch(client *http.Client, url string) (*http.Response, string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp, string(body), err
+}
+
+func TestNoFaults(t *testing.T) {
+	ts := newServer()
+	defer ts.Close()
+
+	chaos := New(Config{Seed: 1}, nil)
+	resp, body, err := fetch(&http.Client{Transport: chaos}, ts.URL)
+	if err != nil || resp.StatusCode != http.StatusOK || body != payload {
+		t.Errorf("test failed - %v %s", err, body)
+	}
+	if stats := chaos.Stats(); stats != (Stats{Requests: 1}) {
+		t.Errorf("test failed - wrong stats %+v", stats)
+	}
+}
+
+func TestEachFault(t *testing.T) {
+	ts := newServer()
+	defer ts.Close()
+
+	cases := []struct {
+		name   string
+		config Config
+		check  func(resp *http.Response, body string, err error) bool
+	}{
+		{"reset", Config{ResetRate: 1}, func(resp *http.Response, body string, err error) bool {
+			return err != nil && strings.Contains(err.Error(), "connection reset")
+		}},
+		{"server error", Config{ServerErrorRate: 1}, func(resp *http.Response, body string, err error) bool {
+			return err == nil && resp.StatusCode >= http.StatusInternalServerError
+		}},
+		{"truncate", Config{TruncateRate: 1}, func(resp *http.Response, body string, err error) bool {
+			return err == io.ErrUnexpectedEOF && len(body) < len(payload) && strings.HasPrefix(payload, body)
+		}},
+		{"wrong content type", Config{WrongContentTypeRate: 1}, func(resp *http.Response, body string, err error) bool {
+			return err == nil && resp.Header.Get("Content-Type") == "text/html" && strings.HasPrefix(body, "<html>")
+		}},
+		{"slow body", Config{SlowBodyRate: 1, SlowBodyDelay: time.Millisecond, SlowBodyChunk: 4}, func(resp *http.Response, body string, err error) bool {
+			return err == nil && body == payload
+		}},
+		{"latency", Config{LatencyRate: 1, Latency: 5 * time.Millisecond}, func(resp *http.Response, body string, err error) bool {
+			return err == nil && body == payload
+		}},
+	}
+	for _, c := range cases {
+		resp, body, err := fetch(&http.Client{Transport: New(c.config, nil)}, ts.URL)
+		if !c.check(resp, body, err) {
+			t.Errorf("test failed - %s: unexpected %v, %q", c.name, err, body)
+		}
+	}
+}
+
+func TestSlowBodyTimeout(t *testing.T) {
+	ts := newServer()
+	defer ts.Close()
+
+	chaos := New(Config{SlowBodyRate: 1, SlowBodyDelay: 50 * time.Millisecond, SlowBodyChunk: 1}, nil)
+	client := &http.Client{Transport: chaos, Timeout: 100 * time.Millisecond}
+	_, _, err := fetch(client, ts.URL)
+	if err == nil {
+		t.Fatalf("test failed - slow body must hit client timeout")
+	}
+	if netErr, ok := err.(interface{ Timeout() bool }); !ok || !netErr.Timeout() {
+		t.Errorf("test failed - expected timeout error, got %v", err)
+	}
+}
+
+func TestLatencyCanceled(t *testing.T) {
+	chaos := New(Config{LatencyRate: 1, Latency: time.Hour}, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://unused.invalid", nil)
+	if _, err := chaos.RoundTrip(req); err != context.DeadlineExceeded {
+		t.Errorf("test failed - latency must stop at ctx deadline, got %v", err)
+	}
+}
+
+func TestSeedDeterminism(t *testing.T) {
+	ts := newServer()
+	defer ts.Close()
+
+	config := Config{Seed: 42, ResetRate: 0.2, ServerErrorRate: 0.2, TruncateRate: 0.2, WrongContentTypeRate: 0.2}
+	outcomes := func() []string {
+		client := &http.Client{Transport: New(config, nil)}
+		result := []string{}
+		for i := 0; i < 50; i++ {
+			resp, body, err := fetch(client, ts.URL)
+			switch {
+			case err != nil:
+				result = append(result, "error")
+			default:
+				result = append(result, resp.Status+" "+body)
+			}
+		}
+		return result
+	}
+
+	first, second := outcomes(), outcomes()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("test failed - request %d differs with same seed: %q and %q", i, first[i], second[i])
+		}
+	}
+}
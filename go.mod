@@ -1,3 +1,3 @@
 module lesson4
 
-go 1.18
+go 1.21
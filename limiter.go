@@ -0,0 +1,129 @@
+package lesson4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrConcurrencyLimit - все места заняты, и ждать их не стали или не дождались
+var ErrConcurrencyLimit = errors.New("too many concurrent requests")
+
+// ErrBadLimiterConfig - у ограничителя нет ни одного места, запрос не пройдёт никогда
+var ErrBadLimiterConfig = errors.New("bad concurrency limiter")
+
+// ConcurrencyLimiter ограничивает число одновременных FindUsers одного SearchClient,
+// чтобы всплеск вызовов не открывал сотни соединений через общий http.Client
+type ConcurrencyLimiter struct {
+	// сколько запросов могут выполняться одновременно, не меньше 1. С меньшим Acquire возвращает ошибку
+	MaxInFlight int
+	// ждать освобождения места, пока не кончится ctx, вместо того чтобы сразу вернуть ErrConcurrencyLimit
+	Queue bool
+	// сколько запросов могут ждать в очереди, 0 - без ограничения. Лишние сразу получают ErrConcurrencyLimit
+	MaxQueue int
+
+	once  sync.Once
+	slots chan struct{}
+
+	mu    sync.Mutex
+	stats LimiterStats
+}
+
+// LimiterStats - состояние ограничителя на момент вызова Stats
+type LimiterStats struct {
+	InFlight int
+	// сколько запросов ждут места сейчас и сколько ждало больше всего одновременно
+	Queued    int
+	MaxQueued int
+	// сколько запросов получили ErrConcurrencyLimit
+	Rejected uint64
+	// сколько запросов дождались места и сколько всего и максимум они ждали
+	Waited    uint64
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+func NewConcurrencyLimiter(maxInFlight int, queue bool) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{MaxInFlight: maxInFlight, Queue: queue}
+}
+
+// Acquire занимает место под запрос. После nil надо вызвать release, когда запрос закончится.
+// Если в очереди не дождались места до конца ctx, ошибка - и ErrConcurrencyLimit, и ctx.Err()
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) (release func(), err error) {
+	if l.MaxInFlight < 1 {
+		//без мест канал небуферизованный: без очереди отказ на каждый запрос, с очередью - вечное ожидание
+		return nil, fmt.Errorf("%w: MaxInFlight must be at least 1, got %d", ErrBadLimiterConfig, l.MaxInFlight)
+	}
+	l.once.Do(func() {
+		l.slots = make(chan struct{}, l.MaxInFlight)
+	})
+
+	select {
+	case l.slots <- struct{}{}:
+		l.update(func(stats *LimiterStats) { stats.InFlight++ })
+		return l.release, nil
+	default:
+	}
+
+	if !l.Queue {
+		l.update(func(stats *LimiterStats) { stats.Rejected++ })
+		return nil, ErrConcurrencyLimit
+	}
+
+	full := false
+	l.update(func(stats *LimiterStats) {
+		if l.MaxQueue > 0 && stats.Queued >= l.MaxQueue {
+			full = true
+			stats.Rejected++
+			return
+		}
+		stats.Queued++
+		if stats.Queued > stats.MaxQueued {
+			stats.MaxQueued = stats.Queued
+		}
+	})
+	if full {
+		return nil, ErrConcurrencyLimit
+	}
+
+	started := time.Now()
+	select {
+	case l.slots <- struct{}{}:
+		wait := time.Since(started)
+		l.update(func(stats *LimiterStats) {
+			stats.Queued--
+			stats.InFlight++
+			stats.Waited++
+			stats.TotalWait += wait
+			if wait > stats.MaxWait {
+				stats.MaxWait = wait
+			}
+		})
+		return l.release, nil
+	case <-ctx.Done():
+		l.update(func(stats *LimiterStats) {
+			stats.Queued--
+			stats.Rejected++
+		})
+		return nil, fmt.Errorf("%w: %w", ErrConcurrencyLimit, ctx.Err())
+	}
+}
+
+func (l *ConcurrencyLimiter) release() {
+	<-l.slots
+	l.update(func(stats *LimiterStats) { stats.InFlight-- })
+}
+
+func (l *ConcurrencyLimiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+func (l *ConcurrencyLimiter) update(change func(stats *LimiterStats)) {
+	l.mu.Lock()
+	change(&l.stats)
+	l.mu.Unlock()
+}
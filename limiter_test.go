@@ -0,0 +1,182 @@
+package lesson4
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gatedServer отвечает на поиск только после того, как тест закроет gate, и считает одновременные запросы
+func gatedServer(gate chan struct{}, inFlight, maxInFlight *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cur := atomic.AddInt32(inFlight, 1)
+		for {
+			max := atomic.LoadInt32(maxInFlight)
+			if cur <= max || atomic.CompareAndSwapInt32(maxInFlight, max, cur) {
+				break
+			}
+		}
+		<-gate
+		atomic.AddInt32(inFlight, -1)
+		SearchServer(w, r)
+	}))
+}
+
+// waitFor ждёт, пока cond станет истинным, не дольше секунды
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("test failed - condition not reached")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLimiterFailFast(t *testing.T) {
+	gate := make(chan struct{})
+	var inFlight, maxInFlight int32
+	ts := gatedServer(gate, &inFlight, &maxInFlight)
+	defer ts.Close()
+
+	limiter := NewConcurrencyLimiter(2, false)
+	client := &SearchClient{AccessToken: "TestToken", URL: ts.URL, Limiter: limiter}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.FindUsers(SearchRequest{Limit: 1})
+		}()
+	}
+	waitFor(t, func() bool { return atomic.LoadInt32(&inFlight) == 2 })
+
+	if _, err := client.FindUsers(SearchRequest{Limit: 1}); err != ErrConcurrencyLimit {
+		t.Errorf("test failed - expected ErrConcurrencyLimit, got %v", err)
+	}
+	close(gate)
+	wg.Wait()
+
+	stats := limiter.Stats()
+	if stats.InFlight != 0 || stats.Rejected != 1 || stats.Waited != 0 {
+		t.Errorf("test failed - wrong stats %+v", stats)
+	}
+	if _, err := client.FindUsers(SearchRequest{Limit: 1}); err != nil {
+		t.Errorf("test failed - slots must be released, got %v", err)
+	}
+}
+
+func TestLimiterQueue(t *testing.T) {
+	gate := make(chan struct{})
+	var inFlight, maxInFlight int32
+	ts := gatedServer(gate, &inFlight, &maxInFlight)
+	defer ts.Close()
+
+	limiter := NewConcurrencyLimiter(2, true)
+	client := &SearchClient{AccessToken: "TestToken", URL: ts.URL, Limiter: limiter}
+
+	var failed int32
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.FindUsers(SearchRequest{Limit: 1}); err != nil {
+				atomic.AddInt32(&failed, 1)
+			}
+		}()
+	}
+	waitFor(t, func() bool { return limiter.Stats().Queued == 3 })
+	time.Sleep(10 * time.Millisecond)
+	close(gate)
+	wg.Wait()
+
+	stats := limiter.Stats()
+	if failed != 0 || maxInFlight != 2 {
+		t.Errorf("test failed - %d failed, %d at once on server", failed, maxInFlight)
+	}
+	if stats.Queued != 0 || stats.MaxQueued != 3 || stats.Waited != 3 || stats.MaxWait < 10*time.Millisecond || stats.TotalWait < stats.MaxWait {
+		t.Errorf("test failed - wrong stats %+v", stats)
+	}
+}
+
+func TestLimiterQueueDeadline(t *testing.T) {
+	gate := make(chan struct{})
+	var inFlight, maxInFlight int32
+	ts := gatedServer(gate, &inFlight, &maxInFlight)
+	defer ts.Close()
+	defer close(gate)
+
+	limiter := NewConcurrencyLimiter(1, true)
+	client := &SearchClient{AccessToken: "TestToken", URL: ts.URL, Limiter: limiter}
+	go client.FindUsers(SearchRequest{Limit: 1})
+	waitFor(t, func() bool { return limiter.Stats().InFlight == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	_, err := client.FindUsersContext(ctx, SearchRequest{Limit: 1})
+	if !errors.Is(err, ErrConcurrencyLimit) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("test failed - expected limit and deadline error, got %v", err)
+	}
+	if time.Since(started) > 500*time.Millisecond {
+		t.Errorf("test failed - queue must give up at ctx deadline")
+	}
+	if stats := limiter.Stats(); stats.Queued != 0 || stats.Rejected != 1 {
+		t.Errorf("test failed - wrong stats %+v", stats)
+	}
+}
+
+func TestLimiterMaxQueue(t *testing.T) {
+	limiter := &ConcurrencyLimiter{MaxInFlight: 1, Queue: true, MaxQueue: 1}
+	release, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("test failed - %s", err)
+	}
+
+	queued := make(chan error)
+	go func() {
+		_, err := limiter.Acquire(context.Background())
+		queued <- err
+	}()
+	waitFor(t, func() bool { return limiter.Stats().Queued == 1 })
+
+	if _, err = limiter.Acquire(context.Background()); err != ErrConcurrencyLimit {
+		t.Errorf("test failed - full queue must reject, got %v", err)
+	}
+	release()
+	if err = <-queued; err != nil {
+		t.Errorf("test failed - queued acquire must pass after release, got %v", err)
+	}
+}
+
+// TestLimiterNoSlots - без мест ограничитель не отказывает молча и не ждёт вечно, а сообщает о настройке
+func TestLimiterNoSlots(t *testing.T) {
+	for _, maxInFlight := range []int{0, -1} {
+		for _, queue := range []bool{false, true} {
+			limiter := NewConcurrencyLimiter(maxInFlight, queue)
+			done := make(chan error, 1)
+			go func() {
+				_, err := limiter.Acquire(context.Background())
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if !errors.Is(err, ErrBadLimiterConfig) || errors.Is(err, ErrConcurrencyLimit) {
+					t.Errorf("%d, queue %v: test failed - expected config error, got %v", maxInFlight, queue, err)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%d, queue %v: test failed - Acquire blocked", maxInFlight, queue)
+			}
+			if stats := limiter.Stats(); stats.Rejected != 0 || stats.InFlight != 0 {
+				t.Errorf("%d, queue %v: test failed - stats changed: %+v", maxInFlight, queue, stats)
+			}
+		}
+	}
+}
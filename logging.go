@@ -0,0 +1,166 @@
+package lesson4
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// чем заменяется секрет в логах
+const redacted = "[REDACTED]"
+
+// redactedKeys - ключи, значения которых никогда не попадают в лог, в любом регистре
+var redactedKeys = map[string]bool{
+	"accesstoken":  true,
+	"access_token": true,
+	"token":        true,
+}
+
+// redactingHandler вычищает секреты из записей перед тем, как отдать их следующему обработчику.
+// Проверяются и атрибуты записи, и атрибуты, добавленные через With, в том числе внутри групп
+type redactingHandler struct {
+	next slog.Handler
+}
+
+// NewRedactingHandler оборачивает h так, что AccessToken и похожие ключи пишутся как [REDACTED]
+func NewRedactingHandler(h slog.Handler) slog.Handler {
+	if _, ok := h.(*redactingHandler); ok {
+		return h
+	}
+	return &redactingHandler{next: h}
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	clean := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		clean.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return h.next.Handle(ctx, clean)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clean := make([]slog.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		clean = append(clean, redactAttr(attr))
+	}
+	return &redactingHandler{next: h.next.WithAttrs(clean)}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{next: h.next.WithGroup(name)}
+}
+
+func redactAttr(attr slog.Attr) slog.Attr {
+	if redactedKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, redacted)
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		group := attr.Value.Group()
+		clean := make([]any, 0, len(group))
+		for _, inner := range group {
+			clean = append(clean, redactAttr(inner))
+		}
+		return slog.Group(attr.Key, clean...)
+	}
+	return attr
+}
+
+// queryAttr - текст поиска для лога. С hash вместо текста пишется начало его sha256:
+// одинаковые запросы всё ещё можно сопоставить, а что искали - нет
+func queryAttr(query string, hash bool) slog.Attr {
+	if !hash {
+		return slog.String("query", query)
+	}
+	sum := sha256.Sum256([]byte(query))
+	return slog.String("query_hash", hex.EncodeToString(sum[:8]))
+}
+
+// outcomeLevel - уровень записи по классу исхода: ошибки клиента - предупреждения, остальное - ошибки
+func outcomeLevel(class string) slog.Level {
+	switch class {
+	case outcomeOK:
+		return slog.LevelInfo
+	case outcomeBadRequest, outcomeUnauthorized:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
+
+// logFindUsers пишет одну запись о завершённом FindUsers
+func (srv *SearchClient) logFindUsers(req SearchRequest, requestID string, started time.Time, err error) {
+	logger := slog.New(NewRedactingHandler(srv.Logger.Handler()))
+
+	status := http.StatusOK
+	var searchErr *SearchError
+	if errors.As(err, &searchErr) {
+		status = searchErr.StatusCode
+	} else if err != nil {
+		//до внешней системы не дошли или не разобрали её ответ
+		status = 0
+	}
+
+	class := outcome(err)
+	attrs := []slog.Attr{
+		slog.String("request_id", requestID),
+		slog.String("url", srv.URL),
+		slog.String("api_version", srv.APIVersion),
+		slog.Int("limit", req.Limit),
+		slog.Int("offset", req.Offset),
+		queryAttr(req.Query, srv.HashQuery),
+		slog.String("order_field", req.OrderField),
+		slog.Int("order_by", req.OrderBy),
+		slog.Int("status", status),
+		slog.String("outcome", class),
+		slog.Duration("duration", time.Since(started)),
+		//клиент запросы не повторяет, поле есть, чтобы записи не поменялись, когда повторы появятся
+		slog.Int("retries", 0),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	logger.LogAttrs(context.Background(), outcomeLevel(class), "FindUsers", attrs...)
+}
+
+// LogRequests пишет запись о каждом запросе к next: метод, путь, параметры поиска, статус и время.
+// Заголовки не пишутся вовсе, а секреты в атрибутах logger вычищаются
+func LogRequests(logger *slog.Logger, hashQuery bool, next http.Handler) http.Handler {
+	if logger == nil {
+		return next
+	}
+	logger = slog.New(NewRedactingHandler(logger.Handler()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		query := r.URL.Query()
+		level := slog.LevelInfo
+		if sw.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		} else if sw.status >= http.StatusBadRequest {
+			level = slog.LevelWarn
+		}
+		logger.LogAttrs(r.Context(), level, "request",
+			slog.String("request_id", r.Header.Get(requestIDHeader)),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("limit", query.Get("limit")),
+			slog.String("offset", query.Get("offset")),
+			queryAttr(query.Get("query"), hashQuery),
+			slog.String("order_field", query.Get("order_field")),
+			slog.String("order_by", query.Get("order_by")),
+			slog.Int("status", sw.status),
+			slog.Duration("duration", time.Since(started)),
+		)
+	})
+}
@@ -0,0 +1,115 @@
+package lesson4
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// logLines разбирает вывод slog.JSONHandler построчно
+func logLines(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, raw := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if raw == "" {
+			continue
+		}
+		var line map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &line); err != nil {
+			t.Fatalf("test failed - bad log line %q: %s", raw, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestClientLogging(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+
+	var out bytes.Buffer
+	client := &SearchClient{AccessToken: "TestToken", URL: ts.URL, Logger: slog.New(slog.NewJSONHandler(&out, nil))}
+	client.FindUsers(SearchRequest{Limit: 2, Query: "Hilda", OrderField: "Age", OrderBy: 1})
+	client.AccessToken = "WrongToken"
+	client.FindUsers(SearchRequest{Limit: 2})
+
+	lines := logLines(t, &out)
+	if len(lines) != 2 {
+		t.Fatalf("test failed - expected 2 log lines, got %d", len(lines))
+	}
+	ok, unauthorized := lines[0], lines[1]
+	if ok["msg"] != "FindUsers" || ok["level"] != "INFO" || ok["status"] != float64(200) || ok["outcome"] != "ok" {
+		t.Errorf("test failed - wrong ok line %v", ok)
+	}
+	if ok["query"] != "Hilda" || ok["order_field"] != "Age" || ok["limit"] != float64(2) || ok["retries"] != float64(0) {
+		t.Errorf("test failed - wrong params in %v", ok)
+	}
+	if _, found := ok["duration"]; !found {
+		t.Errorf("test failed - no duration in %v", ok)
+	}
+	if unauthorized["level"] != "WARN" || unauthorized["status"] != float64(401) || unauthorized["request_id"] == "" {
+		t.Errorf("test failed - wrong unauthorized line %v", unauthorized)
+	}
+	if strings.Contains(out.String(), "TestToken") || strings.Contains(out.String(), "WrongToken") {
+		t.Errorf("test failed - token in log:\n%s", out.String())
+	}
+}
+
+func TestClientLoggingHashQuery(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+
+	var out bytes.Buffer
+	client := &SearchClient{AccessToken: "TestToken", URL: ts.URL, HashQuery: true, Logger: slog.New(slog.NewJSONHandler(&out, nil))}
+	client.FindUsers(SearchRequest{Limit: 2, Query: "Hilda"})
+	client.FindUsers(SearchRequest{Limit: 2, Query: "Hilda"})
+
+	lines := logLines(t, &out)
+	if strings.Contains(out.String(), "Hilda") {
+		t.Errorf("test failed - query text in log:\n%s", out.String())
+	}
+	if lines[0]["query_hash"] == nil || lines[0]["query_hash"] != lines[1]["query_hash"] {
+		t.Errorf("test failed - same query must give same hash: %v, %v", lines[0]["query_hash"], lines[1]["query_hash"])
+	}
+}
+
+func TestRedactingHandler(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(NewRedactingHandler(slog.NewJSONHandler(&out, nil)))
+	logger.With("AccessToken", "secret1").Info("with", "token", "secret2", slog.Group("headers", "access_token", "secret3", "accept", "text/plain"))
+
+	if strings.Contains(out.String(), "secret") {
+		t.Errorf("test failed - secret in log:\n%s", out.String())
+	}
+	if strings.Count(out.String(), redacted) != 3 || !strings.Contains(out.String(), "text/plain") {
+		t.Errorf("test failed - wrong redaction:\n%s", out.String())
+	}
+}
+
+func TestServerLogging(t *testing.T) {
+	var out bytes.Buffer
+	serverLogger, serverHashQuery = slog.New(slog.NewJSONHandler(&out, nil)), true
+	defer func() { serverLogger, serverHashQuery = nil, false }()
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	(&SearchClient{AccessToken: "TestToken", URL: ts.URL}).FindUsers(SearchRequest{Limit: 1, Query: "Hilda"})
+	(&SearchClient{AccessToken: "WrongToken", URL: ts.URL}).FindUsers(SearchRequest{Limit: 1})
+
+	lines := logLines(t, &out)
+	if len(lines) != 2 {
+		t.Fatalf("test failed - expected 2 log lines, got %d", len(lines))
+	}
+	if lines[0]["status"] != float64(200) || lines[0]["path"] != "/" || lines[0]["limit"] != "2" || lines[0]["query_hash"] == nil {
+		t.Errorf("test failed - wrong ok line %v", lines[0])
+	}
+	if lines[1]["status"] != float64(401) || lines[1]["level"] != "WARN" || lines[1]["request_id"] == "" {
+		t.Errorf("test failed - wrong unauthorized line %v", lines[1])
+	}
+	if strings.Contains(out.String(), "Hilda") || strings.Contains(out.String(), "Token") {
+		t.Errorf("test failed - secret or query text in log:\n%s", out.String())
+	}
+}
@@ -0,0 +1,244 @@
+package lesson4
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// метрики отдаются в текстовом формате Prometheus, без сторонних библиотек
+
+// CounterVec - счётчики с одной меткой
+type CounterVec struct {
+	name  string
+	help  string
+	label string
+
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+func newCounterVec(name, help, label string) *CounterVec {
+	return &CounterVec{name: name, help: help, label: label, values: map[string]uint64{}}
+}
+
+func (c *CounterVec) Inc(labelValue string) {
+	c.mu.Lock()
+	c.values[labelValue]++
+	c.mu.Unlock()
+}
+
+// Get - текущее значение счётчика, нужно в основном тестам
+func (c *CounterVec) Get(labelValue string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[labelValue]
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	labelValues := make([]string, 0, len(c.values))
+	for labelValue := range c.values {
+		labelValues = append(labelValues, labelValue)
+	}
+	sort.Strings(labelValues)
+	for _, labelValue := range labelValues {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", c.name, c.label, labelValue, c.values[labelValue])
+	}
+}
+
+// Histogram - распределение значений по корзинам с верхними границами buckets
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// defaultLatencyBuckets - корзины для времени ответа в секундах, клиент ждёт не больше секунды
+var defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+func newHistogram(name, help string, buckets []float64) *Histogram {
+	return &Histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// Count и Sum - сколько значений наблюдали и их сумма
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) Sum() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sum
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", h.name, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+// Gauge - значение, которое может и расти, и уменьшаться
+type Gauge struct {
+	name  string
+	help  string
+	value int64
+}
+
+func (g *Gauge) Add(delta int64) {
+	atomic.AddInt64(&g.value, delta)
+}
+
+func (g *Gauge) Get() int64 {
+	return atomic.LoadInt64(&g.value)
+}
+
+func (g *Gauge) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", g.name, g.help, g.name, g.name, g.Get())
+}
+
+// классы исхода запроса для метрик клиента
+const (
+	outcomeOK           = "ok"
+	outcomeTimeout      = "timeout"
+	outcomeUnauthorized = "unauthorized"
+	outcomeBadRequest   = "bad_request"
+	outcomeFatal        = "fatal"
+)
+
+// outcome - класс исхода по ошибке FindUsers. Всё, что не удалось отнести к другим классам
+// (сеть, битый json в ответе), считается fatal: результата нет и повтор вряд ли поможет
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return outcomeOK
+	case errors.Is(err, ErrTimeout):
+		return outcomeTimeout
+	case errors.Is(err, ErrUnauthorized):
+		return outcomeUnauthorized
+	case errors.Is(err, ErrBadRequest):
+		return outcomeBadRequest
+	}
+	return outcomeFatal
+}
+
+// ClientMetrics - метрики SearchClient. Один экземпляр можно отдать нескольким клиентам
+type ClientMetrics struct {
+	Requests *CounterVec
+	Latency  *Histogram
+	InFlight *Gauge
+}
+
+func NewClientMetrics() *ClientMetrics {
+	return &ClientMetrics{
+		Requests: newCounterVec("searchclient_requests_total", "Запросы FindUsers по классу исхода.", "outcome"),
+		Latency:  newHistogram("searchclient_request_duration_seconds", "Время выполнения FindUsers.", defaultLatencyBuckets),
+		InFlight: &Gauge{name: "searchclient_in_flight_requests", help: "Запросы FindUsers, которые выполняются прямо сейчас."},
+	}
+}
+
+// start отмечает начало запроса, возвращённую функцию надо вызвать с его результатом
+func (m *ClientMetrics) start() func(err error) {
+	started := time.Now()
+	m.InFlight.Add(1)
+	return func(err error) {
+		m.InFlight.Add(-1)
+		m.Latency.Observe(time.Since(started).Seconds())
+		m.Requests.Inc(outcome(err))
+	}
+}
+
+func (m *ClientMetrics) WritePrometheus(w io.Writer) {
+	m.Requests.write(w)
+	m.Latency.write(w)
+	m.InFlight.write(w)
+}
+
+// ServeHTTP отдаёт метрики, чтобы их можно было повесить на /metrics
+func (m *ClientMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeMetrics(w, m.WritePrometheus)
+}
+
+// ServerMetrics - метрики внешней системы поиска
+type ServerMetrics struct {
+	Responses   *CounterVec
+	OrderFields *CounterVec
+}
+
+func NewServerMetrics() *ServerMetrics {
+	return &ServerMetrics{
+		Responses:   newCounterVec("searchserver_responses_total", "Ответы по HTTP-статусу.", "status"),
+		OrderFields: newCounterVec("searchserver_order_field_total", "Поиски по полю сортировки.", "order_field"),
+	}
+}
+
+func (m *ServerMetrics) WritePrometheus(w io.Writer) {
+	m.Responses.write(w)
+	m.OrderFields.write(w)
+}
+
+func (m *ServerMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeMetrics(w, m.WritePrometheus)
+}
+
+// Middleware считает ответы next по статусу
+func (m *ServerMetrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+		m.Responses.Inc(strconv.Itoa(sw.status))
+	})
+}
+
+// statusWriter запоминает статус, который записал обработчик
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func writeMetrics(w http.ResponseWriter, write func(w io.Writer)) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	write(w)
+}
@@ -0,0 +1,124 @@
+package lesson4
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientMetricsOutcomes(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	metrics := NewClientMetrics()
+	client := &SearchClient{AccessToken: "TestToken", URL: ts.URL, Metrics: metrics}
+	client.FindUsers(SearchRequest{Limit: 1})
+	client.FindUsers(SearchRequest{Limit: 1, OrderField: "Gender"})
+	(&SearchClient{AccessToken: "WrongToken", URL: ts.URL, Metrics: metrics}).FindUsers(SearchRequest{Limit: 1})
+
+	expected := map[string]uint64{outcomeOK: 1, outcomeBadRequest: 1, outcomeUnauthorized: 1, outcomeTimeout: 0, outcomeFatal: 0}
+	for class, count := range expected {
+		if got := metrics.Requests.Get(class); got != count {
+			t.Errorf("test failed - %s requests: expected %d, got %d", class, count, got)
+		}
+	}
+	if metrics.InFlight.Get() != 0 {
+		t.Errorf("test failed - in flight must be 0 after requests, got %d", metrics.InFlight.Get())
+	}
+	if metrics.Latency.Count() != 3 {
+		t.Errorf("test failed - latency must have 3 observations, got %d", metrics.Latency.Count())
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected string
+	}{
+		{nil, outcomeOK},
+		{&TimeoutError{Target: "limit=1"}, outcomeTimeout},
+		{&SearchError{StatusCode: http.StatusUnauthorized}, outcomeUnauthorized},
+		{&ValidationError{}, outcomeBadRequest},
+		{&SearchError{StatusCode: http.StatusInternalServerError}, outcomeFatal},
+		{errors.New("unknown error connection refused"), outcomeFatal},
+	}
+	for _, c := range cases {
+		if got := outcome(c.err); got != c.expected {
+			t.Errorf("test failed - outcome(%v): expected %s, got %s", c.err, c.expected, got)
+		}
+	}
+}
+
+func TestClientMetricsText(t *testing.T) {
+	metrics := NewClientMetrics()
+	metrics.Requests.Inc(outcomeOK)
+	metrics.Requests.Inc(outcomeOK)
+	metrics.Requests.Inc(outcomeFatal)
+	metrics.Latency.Observe(0.02)
+	metrics.Latency.Observe(3)
+	metrics.InFlight.Add(1)
+
+	var out bytes.Buffer
+	metrics.WritePrometheus(&out)
+
+	for _, line := range []string{
+		"# TYPE searchclient_requests_total counter",
+		`searchclient_requests_total{outcome="fatal"} 1`,
+		`searchclient_requests_total{outcome="ok"} 2`,
+		"# TYPE searchclient_request_duration_seconds histogram",
+		`searchclient_request_duration_seconds_bucket{le="0.01"} 0`,
+		`searchclient_request_duration_seconds_bucket{le="0.025"} 1`,
+		`searchclient_request_duration_seconds_bucket{le="2.5"} 1`,
+		`searchclient_request_duration_seconds_bucket{le="+Inf"} 2`,
+		"searchclient_request_duration_seconds_sum 3.02",
+		"searchclient_request_duration_seconds_count 2",
+		"# TYPE searchclient_in_flight_requests gauge",
+		"searchclient_in_flight_requests 1",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("test failed - no line %q in:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestServerMetricsEndpoint(t *testing.T) {
+	serverMetrics = NewServerMetrics()
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	client := &SearchClient{AccessToken: "TestToken", URL: ts.URL}
+	client.FindUsers(SearchRequest{Limit: 1, OrderField: "Age"})
+	client.FindUsers(SearchRequest{Limit: 1, OrderField: "age"})
+	client.FindUsers(SearchRequest{Limit: 1})
+	(&SearchClient{AccessToken: "WrongToken", URL: ts.URL}).FindUsers(SearchRequest{Limit: 1})
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("test failed - %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("test failed - metrics content type %q", resp.Header.Get("Content-Type"))
+	}
+	for _, line := range []string{
+		`searchserver_responses_total{status="200"} 3`,
+		`searchserver_responses_total{status="401"} 1`,
+		`searchserver_order_field_total{order_field="age"} 2`,
+		`searchserver_order_field_total{order_field="name"} 1`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("test failed - no line %q in:\n%s", line, body)
+		}
+	}
+	//сам запрос /metrics попадает в счётчик уже после того, как метрики отданы
+	if got := serverMetrics.Responses.Get(fmt.Sprint(http.StatusOK)); got != 4 {
+		t.Errorf("test failed - 200 responses with /metrics: expected 4, got %d", got)
+	}
+}
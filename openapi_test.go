@@ -0,0 +1,445 @@
+package lesson4
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"lesson4/fakesearch"
+	"lesson4/schema"
+)
+
+// openAPISpec - та часть OpenAPI 3, которую проверяют тесты
+type openAPISpec struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Parameters map[string]openAPIParameter       `json:"parameters"`
+		Responses  map[string]openAPIResponse        `json:"responses"`
+		Schemas    map[string]map[string]interface{} `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Parameters []openAPIParameter         `json:"parameters"`
+	Responses  map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Ref    string                 `json:"$ref"`
+	Name   string                 `json:"name"`
+	In     string                 `json:"in"`
+	Schema map[string]interface{} `json:"schema"`
+}
+
+type openAPIResponse struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema map[string]interface{} `json:"schema"`
+	} `json:"content"`
+}
+
+func loadOpenAPISpec(t *testing.T, data []byte) *openAPISpec {
+	spec := &openAPISpec{}
+	if err := json.Unmarshal(data, spec); err != nil {
+		t.Fatalf("cant unpack openapi.json: %v", err)
+	}
+	return spec
+}
+
+// operation находит описание операции по реальному пути запроса, подставляя {параметры} из шаблонов
+func (spec *openAPISpec) operation(method, path string) (openAPIOperation, bool) {
+	if ops, ok := spec.Paths[path]; ok {
+		op, ok := ops[strings.ToLower(method)]
+		return op, ok
+	}
+	for template, ops := range spec.Paths {
+		if !matchPath(template, path) {
+			continue
+		}
+		op, ok := ops[strings.ToLower(method)]
+		return op, ok
+	}
+	return openAPIOperation{}, false
+}
+
+func matchPath(template, path string) bool {
+	tParts := strings.Split(template, "/")
+	pParts := strings.Split(path, "/")
+	if len(tParts) != len(pParts) {
+		return false
+	}
+	for i := range tParts {
+		if strings.HasPrefix(tParts[i], "{") || tParts[i] == pParts[i] {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// parameter - описание параметра с учётом $ref
+func (spec *openAPISpec) parameter(param openAPIParameter) openAPIParameter {
+	if param.Ref != "" {
+		return spec.Components.Parameters[strings.TrimPrefix(param.Ref, "#/components/parameters/")]
+	}
+	return param
+}
+
+// schemaType - тип json-схемы с учётом $ref
+func (spec *openAPISpec) schemaType(sch map[string]interface{}) string {
+	if ref, ok := sch["$ref"].(string); ok {
+		return spec.schemaType(spec.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")])
+	}
+	typ, _ := sch["type"].(string)
+	return typ
+}
+
+// jsonType - тип json-схемы, в который кодируется значение типа t. Для json.RawMessage - пустая строка, там может быть что угодно
+func jsonType(t reflect.Type) string {
+	if t == reflect.TypeOf(json.RawMessage{}) {
+		return ""
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "integer"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice:
+		return "array"
+	case reflect.Struct:
+		return "object"
+	}
+	return t.Kind().String()
+}
+
+func (spec *openAPISpec) response(op openAPIOperation, status int) (openAPIResponse, bool) {
+	resp, ok := op.Responses[fmt.Sprint(status)]
+	if ok && resp.Ref != "" {
+		resp, ok = spec.Components.Responses[strings.TrimPrefix(resp.Ref, "#/components/responses/")]
+	}
+	return resp, ok
+}
+
+// validate проверяет значение по json-схеме. Поддерживается подмножество, которое есть в openapi.json
+func (spec *openAPISpec) validate(value interface{}, sch map[string]interface{}, path string) error {
+	if ref, ok := sch["$ref"].(string); ok {
+		return spec.validate(value, spec.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")], path)
+	}
+	if oneOf, ok := sch["oneOf"].([]interface{}); ok {
+		for _, variant := range oneOf {
+			if spec.validate(value, variant.(map[string]interface{}), path) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: matches none of oneOf", path)
+	}
+	if enum, ok := sch["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if reflect.DeepEqual(allowed, value) {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not in enum", path, value)
+		}
+	}
+
+	switch sch["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be object", path)
+		}
+		properties, _ := sch["properties"].(map[string]interface{})
+		required, _ := sch["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				return fmt.Errorf("%s: required property %s is missing", path, name)
+			}
+		}
+		for name, propValue := range obj {
+			propSchema, ok := properties[name].(map[string]interface{})
+			if !ok {
+				if sch["additionalProperties"] == false {
+					return fmt.Errorf("%s: unexpected property %s", path, name)
+				}
+				continue
+			}
+			if err := spec.validate(propValue, propSchema, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be array", path)
+		}
+		items, _ := sch["items"].(map[string]interface{})
+		for i, item := range arr {
+			if err := spec.validate(item, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: must be string", path)
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != float64(int64(number)) {
+			return fmt.Errorf("%s: must be integer", path)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: must be boolean", path)
+		}
+	}
+	return nil
+}
+
+func TestOpenAPIServed(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/openapi.json")
+	if err != nil {
+		t.Fatalf("error happened: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+
+	spec := loadOpenAPISpec(t, data)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != schema.MediaTypeJSON || !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("test failed - wrong spec response %d %s", resp.StatusCode, spec.OpenAPI)
+	}
+}
+
+// Описание должно совпадать с тем, что реально отвечает SearchServer
+func TestOpenAPIMatchesServer(t *testing.T) {
+	data, err := ioutil.ReadFile("openapi.json")
+	if err != nil {
+		t.Fatalf("cant read openapi.json: %v", err)
+	}
+	spec := loadOpenAPISpec(t, data)
+
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	cases := []struct {
+		method string
+		path   string
+		query  string
+		token  string
+		accept string
+		body   string
+		status int
+	}{
+		{"GET", "/v1/users/search", "limit=3&order_field=Age&order_by=-1", "TestToken", "", "", http.StatusOK},
+		{"GET", "/v1/users/search", "query=SomeWrongParameter", "TestToken", "", "", http.StatusOK},
+		{"GET", "/v1/users/search", "limit=x&order_field=badfield", "TestToken", "", "", http.StatusBadRequest},
+		{"GET", "/v1/users/search", "", "WrongToken", "", "", http.StatusUnauthorized},
+		{"GET", "/v1/users/search", "", "TestToken", "text/xml", "", http.StatusNotAcceptable},
+		{"POST", "/v1/users/search", "", "TestToken", "", `{"limit":2}`, http.StatusOK},
+		{"GET", "/v2/users/search", "limit=2&offset=1", "TestToken", schema.MediaTypeV2, "", http.StatusOK},
+		{"POST", "/v1/users/batch", "", "TestToken", "", `[{"limit":2},{"order_field":"bad"}]`, http.StatusOK},
+		{"GET", "/v1/users/3", "", "TestToken", "", "", http.StatusOK},
+		{"GET", "/v1/users/1000", "", "TestToken", "", "", http.StatusNotFound},
+		{"GET", "/v1/users", "id=1&id=2", "TestToken", "", "", http.StatusOK},
+	}
+	for _, c := range cases {
+		name := fmt.Sprintf("%s %s?%s", c.method, c.path, c.query)
+
+		op, ok := spec.operation(c.method, c.path)
+		if !ok {
+			t.Errorf("%s: operation is not described", name)
+			continue
+		}
+		for _, param := range op.Parameters {
+			if param.Ref != "" {
+				if _, ok := spec.Components.Parameters[strings.TrimPrefix(param.Ref, "#/components/parameters/")]; !ok {
+					t.Errorf("%s: unknown parameter %s", name, param.Ref)
+				}
+			}
+		}
+
+		req, _ := http.NewRequest(c.method, ts.URL+c.path+"?"+c.query, strings.NewReader(c.body))
+		req.Header.Add("AccessToken", c.token)
+		req.Header.Set("Content-Type", schema.MediaTypeJSON)
+		if c.accept != "" {
+			req.Header.Set("Accept", c.accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("%s: error happened: %v", name, err)
+			continue
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("%s: wrong status %d", name, resp.StatusCode)
+			continue
+		}
+
+		described, ok := spec.response(op, resp.StatusCode)
+		if !ok {
+			t.Errorf("%s: status %d is not described", name, resp.StatusCode)
+			continue
+		}
+		contentType := resp.Header.Get("Content-Type")
+		media, ok := described.Content[contentType]
+		if !ok {
+			t.Errorf("%s: content type %q is not described", name, contentType)
+			continue
+		}
+
+		var value interface{}
+		if err = json.Unmarshal(data, &value); err != nil {
+			t.Errorf("%s: cant unpack response: %v", name, err)
+			continue
+		}
+		if err = spec.validate(value, media.Schema, "body"); err != nil {
+			t.Errorf("%s: response does not match spec: %v", name, err)
+		}
+	}
+}
+
+// Все коды ошибок из schema должны быть в описании
+func TestOpenAPIErrorCodes(t *testing.T) {
+	data, err := ioutil.ReadFile("openapi.json")
+	if err != nil {
+		t.Fatalf("cant read openapi.json: %v", err)
+	}
+	spec := loadOpenAPISpec(t, data)
+
+	codes := []string{
+		schema.CodeBadAccessToken, schema.CodeBadLimit, schema.CodeBadOffset, schema.CodeBadOrderBy,
+		schema.CodeBadOrderField, schema.CodeBadBody, schema.CodeBadId, schema.CodeUserNotFound,
+		schema.CodeMethodNotAllowed, schema.CodeNotAcceptable, schema.CodeUnsupportedMediaType, schema.CodeUnavailable,
+	}
+	codeSchema := spec.Components.Schemas["ErrorCode"]
+	for _, code := range codes {
+		if err := spec.validate(code, codeSchema, "code"); err != nil {
+			t.Errorf("test failed - %v", err)
+		}
+	}
+}
+
+// Схемы в описании должны совпадать с типами из schema: те же поля, те же типы,
+// и обязательным не может быть поле, которое при пустом значении не кодируется
+func TestOpenAPISchemasMatchTypes(t *testing.T) {
+	data, err := ioutil.ReadFile("openapi.json")
+	if err != nil {
+		t.Fatalf("cant read openapi.json: %v", err)
+	}
+	spec := loadOpenAPISpec(t, data)
+
+	for _, value := range []interface{}{User{}, SearchRequest{}, SearchResponse{}, FieldError{}, SearchErrorResponse{}, SearchBatchResult{}} {
+		typ := reflect.TypeOf(value)
+		sch, ok := spec.Components.Schemas[typ.Name()]
+		if !ok {
+			t.Errorf("%s: schema is not described", typ.Name())
+			continue
+		}
+		properties, _ := sch["properties"].(map[string]interface{})
+		required := map[string]bool{}
+		list, _ := sch["required"].([]interface{})
+		for _, name := range list {
+			required[name.(string)] = true
+		}
+
+		fields := map[string]bool{}
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			tag := strings.Split(field.Tag.Get("json"), ",")
+			name := tag[0]
+			fields[name] = true
+
+			prop, ok := properties[name].(map[string]interface{})
+			if !ok {
+				t.Errorf("%s: field %s is not described", typ.Name(), name)
+				continue
+			}
+			if kind := jsonType(field.Type); kind != "" && kind != spec.schemaType(prop) {
+				t.Errorf("%s: field %s is %s, described as %q", typ.Name(), name, kind, spec.schemaType(prop))
+			}
+			if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+				items, _ := prop["items"].(map[string]interface{})
+				if items["$ref"] != "#/components/schemas/"+field.Type.Elem().Name() {
+					t.Errorf("%s: items of %s must be %s, described as %v", typ.Name(), name, field.Type.Elem().Name(), items)
+				}
+			}
+			if len(tag) > 1 && tag[1] == "omitempty" && required[name] {
+				t.Errorf("%s: field %s is omitted when empty, but described as required", typ.Name(), name)
+			}
+		}
+		for name := range properties {
+			if !fields[name] {
+				t.Errorf("%s: described property %s has no field", typ.Name(), name)
+			}
+		}
+		for name := range required {
+			if !fields[name] {
+				t.Errorf("%s: required property %s has no field", typ.Name(), name)
+			}
+		}
+	}
+}
+
+// Параметры поиска в описании - ровно те, что клиент отправляет в GET, и их типы совпадают с полями SearchRequest
+func TestOpenAPISearchParameters(t *testing.T) {
+	data, err := ioutil.ReadFile("openapi.json")
+	if err != nil {
+		t.Fatalf("cant read openapi.json: %v", err)
+	}
+	spec := loadOpenAPISpec(t, data)
+
+	ts := fakesearch.New(t)
+	for _, version := range []string{APIVersionV1, APIVersionV2} {
+		client := &SearchClient{AccessToken: "TestToken", URL: ts.URL, APIVersion: version}
+		client.FindUsers(SearchRequest{Limit: 1, Query: "Hilda"})
+		sent := ts.LastRequest()
+
+		op, ok := spec.operation(sent.Method, sent.Path)
+		if !ok {
+			t.Errorf("%s %s: operation is not described", sent.Method, sent.Path)
+			continue
+		}
+		described := map[string]bool{}
+		for _, param := range op.Parameters {
+			if param = spec.parameter(param); param.In == "query" {
+				described[param.Name] = true
+				if _, ok := sent.Query[param.Name]; !ok {
+					t.Errorf("%s: described parameter %s is not sent by client", sent.Path, param.Name)
+				}
+			}
+		}
+		for name := range sent.Query {
+			if !described[name] {
+				t.Errorf("%s: parameter %s is not described", sent.Path, name)
+			}
+		}
+	}
+
+	typ := reflect.TypeOf(SearchRequest{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		param, ok := spec.Components.Parameters[name]
+		if !ok {
+			t.Errorf("test failed - SearchRequest.%s has no parameter %s", field.Name, name)
+			continue
+		}
+		if kind := jsonType(field.Type); kind != spec.schemaType(param.Schema) {
+			t.Errorf("test failed - parameter %s is %s, described as %q", name, kind, spec.schemaType(param.Schema))
+		}
+	}
+}
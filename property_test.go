@@ -0,0 +1,212 @@
+package lesson4
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// expectedSearch - модель поиска, написанная отдельно от searchUsers: фильтр по query,
+// сортировка по полю с Id при равенстве, без пагинации. orderBy 1 - по возрастанию, -1 - по убыванию
+func expectedSearch(rows []Row, query, orderField string, orderBy int) []User {
+	users := []User{}
+	for _, row := range rows {
+		user := rowToUser(row)
+		if query == "" || strings.Contains(user.Name, query) || strings.Contains(user.About, query) {
+			users = append(users, user)
+		}
+	}
+	if orderBy == 0 {
+		return users
+	}
+
+	compare := func(a, b User) int {
+		switch strings.ToLower(orderField) {
+		case "id":
+			return a.Id - b.Id
+		case "age":
+			if a.Age != b.Age {
+				return a.Age - b.Age
+			}
+		default:
+			if c := strings.Compare(a.Name, b.Name); c != 0 {
+				return c
+			}
+		}
+		return a.Id - b.Id
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return compare(users[i], users[j])*orderBy < 0
+	})
+	return users
+}
+
+// randomSearchRequest - валидный запрос. Query берётся из кусков реальных имён и описаний,
+// чтобы фильтр находил и много записей, и мало, и ни одной
+func randomSearchRequest(rng *rand.Rand, rows []Row) SearchRequest {
+	req := SearchRequest{
+		Limit:      1 + rng.Intn(DefaultValidationRules.MaxLimit),
+		OrderField: []string{"", "Id", "id", "Age", "Name", "NAME"}[rng.Intn(6)],
+		OrderBy:    rng.Intn(3) - 1,
+	}
+	switch rng.Intn(4) {
+	case 0:
+	case 1:
+		req.Query = "no such user"
+	default:
+		row := rows[rng.Intn(len(rows))]
+		text := row.LastName + " " + row.FirstName + " " + row.About
+		start := rng.Intn(len(text))
+		end := start + 1 + rng.Intn(3)
+		if end > len(text) {
+			end = len(text)
+		}
+		req.Query = text[start:end]
+	}
+	return req
+}
+
+func userIds(users []User) []int {
+	ids := make([]int, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.Id)
+	}
+	return ids
+}
+
+func equalIds(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestPropertyPagesConcatenate листает случайные запросы через FindUsers для обеих версий API:
+// страницы без пропусков и повторов складываются в полный отсортированный результат,
+// а NextPage выставлен ровно у страниц, после которых что-то осталось
+func TestPropertyPagesConcatenate(t *testing.T) {
+	rows, err := loadRows()
+	if err != nil {
+		t.Fatalf("test failed - %s", err)
+	}
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	rng := rand.New(rand.NewSource(47))
+	for _, version := range []string{APIVersionV1, APIVersionV2} {
+		client := &SearchClient{AccessToken: "TestToken", URL: ts.URL, APIVersion: version}
+		for i := 0; i < 100; i++ {
+			req := randomSearchRequest(rng, rows)
+			expected := userIds(expectedSearch(rows, req.Query, req.OrderField, req.OrderBy))
+
+			got := []int{}
+			seen := map[int]bool{}
+			for page := 0; ; page++ {
+				req.Offset = page * req.Limit
+				result, err := client.FindUsers(req)
+				if err != nil {
+					t.Fatalf("%s: test failed - %+v: %s", version, req, err)
+				}
+				for _, user := range result.Users {
+					if seen[user.Id] {
+						t.Errorf("%s: test failed - %+v: user %d on two pages", version, req, user.Id)
+					}
+					seen[user.Id] = true
+				}
+				got = append(got, userIds(result.Users)...)
+
+				hasMore := req.Offset+req.Limit < len(expected)
+				if result.NextPage != hasMore {
+					t.Errorf("%s: test failed - %+v: NextPage %v, %d users in total", version, req, result.NextPage, len(expected))
+				}
+				if !result.NextPage {
+					break
+				}
+				if page > len(rows) {
+					t.Fatalf("%s: test failed - %+v: NextPage never ends", version, req)
+				}
+			}
+			if !equalIds(got, expected) {
+				t.Errorf("%s: test failed - %+v: pages %v, expected %v", version, req, got, expected)
+			}
+		}
+	}
+}
+
+// TestPropertySortIsTotal - при полном порядке результат не зависит от того, в каком порядке записи лежали в датасете
+func TestPropertySortIsTotal(t *testing.T) {
+	rows, err := loadRows()
+	if err != nil {
+		t.Fatalf("test failed - %s", err)
+	}
+
+	rng := rand.New(rand.NewSource(47))
+	for _, orderField := range []string{"id", "age", "name"} {
+		for _, orderBy := range []int{-1, 1} {
+			params := searchParams{orderField: orderField, orderBy: orderBy}
+			first, _ := searchUsers(context.Background(), rows, params)
+			for i := 0; i < 20; i++ {
+				shuffled := append([]Row(nil), rows...)
+				rng.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+				users, _ := searchUsers(context.Background(), shuffled, params)
+				if !equalIds(userIds(users), userIds(first)) {
+					t.Fatalf("test failed - %s %d: order depends on input order: %v and %v", orderField, orderBy, userIds(users), userIds(first))
+				}
+			}
+		}
+	}
+}
+
+// FuzzSearchUsers сверяет searchUsers с моделью: для валидных параметров результат - окно
+// [offset, offset+limit) полного отсортированного результата, нулевые limit и offset - весь результат
+func FuzzSearchUsers(f *testing.F) {
+	rows, err := loadRows()
+	if err != nil {
+		f.Fatalf("test failed - %s", err)
+	}
+	f.Add(1, 1, "", "", 0)
+	f.Add(25, 0, "", "Age", 1)
+	f.Add(5, 30, "a", "name", -1)
+	f.Add(0, 0, "Hilda", "id", 1)
+	f.Add(3, 1000, "", "Id", -1)
+	f.Add(1, math.MaxInt, "", "", 0)
+	f.Fuzz(func(t *testing.T, limit, offset int, query, orderField string, orderBy int) {
+		params := searchParams{limit: limit, offset: offset, query: query, orderField: orderField, orderBy: orderBy}
+		if checkSearchParams(&params, serverRules, nil) != nil {
+			return
+		}
+
+		before := userIds(expectedSearch(rows, "", "", 0))
+		users, sErr := searchUsers(context.Background(), rows, params)
+		if sErr != nil {
+			t.Fatalf("test failed - %+v: %s", params, sErr.message)
+		}
+		if !equalIds(userIds(expectedSearch(rows, "", "", 0)), before) {
+			t.Fatalf("test failed - searchUsers changed rows")
+		}
+
+		expected := expectedSearch(rows, params.query, params.orderField, params.orderBy)
+		if limit > 0 || offset > 0 {
+			start, size := offset, limit
+			if start > len(expected) {
+				start = len(expected)
+			}
+			if size > len(expected)-start {
+				size = len(expected) - start
+			}
+			expected = expected[start : start+size]
+		}
+		if !equalIds(userIds(users), userIds(expected)) {
+			t.Errorf("test failed - %+v: got %v, expected %v", params, userIds(users), userIds(expected))
+		}
+	})
+}
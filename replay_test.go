@@ -0,0 +1,59 @@
+package lesson4
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"lesson4/searchreplay"
+)
+
+// TestReplayFindUsers гоняет FindUsers по записанным ответам внешней системы без сети.
+// Перезаписать testdata/findusers.golden.json: go test -run TestReplay -replay-update
+func TestReplayFindUsers(t *testing.T) {
+	searchURL := "http://search.invalid"
+	if searchreplay.Updating() {
+		ts := httptest.NewServer(searchMux())
+		defer ts.Close()
+		searchURL = ts.URL
+	}
+
+	transport := searchreplay.Transport(t, "testdata/findusers.golden.json", nil)
+	client := &SearchClient{
+		AccessToken: "TestToken",
+		URL:         searchURL,
+		APIVersion:  APIVersionV1,
+		HTTPClient:  &http.Client{Transport: transport},
+	}
+
+	result, err := client.FindUsers(SearchRequest{Limit: 3, OrderField: "Age", OrderBy: 1})
+	if err != nil || len(result.Users) != 3 || !result.NextPage {
+		t.Fatalf("test failed - %v, %v", result, err)
+	}
+	if result.Users[0].Age > result.Users[1].Age || result.Users[1].Age > result.Users[2].Age {
+		t.Errorf("test failed - users must be sorted by age: %v", result.Users)
+	}
+
+	result, err = client.FindUsers(SearchRequest{Limit: 5, Query: "Hilda"})
+	if err != nil || len(result.Users) != 1 || result.NextPage {
+		t.Errorf("test failed - %v, %v", result, err)
+	}
+
+	client.UseJSONBody = true
+	result, err = client.FindUsers(SearchRequest{Limit: 2, Offset: 34})
+	if err != nil || len(result.Users) != 1 || result.NextPage {
+		t.Errorf("test failed - %v, %v", result, err)
+	}
+
+	client.UseJSONBody = false
+	_, err = client.FindUsers(SearchRequest{Limit: 1, Offset: 1000, OrderField: "id", OrderBy: -1})
+	if err != nil {
+		t.Errorf("test failed - %s", err)
+	}
+
+	client.AccessToken = "WrongToken"
+	if _, err = client.FindUsers(SearchRequest{Limit: 1}); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("test failed - expected unauthorized, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package lesson4
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+)
+
+// заголовок, в котором id запроса уходит во внешнюю систему и возвращается обратно
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// ContextWithRequestID задаёт id, с которым FindUsersContext пойдёт во внешнюю систему.
+// Так id входящего запроса можно пронести до внешней системы и найти по нему обе записи в логах
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext - id из ctx или пустая строка
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ensureRequestID возвращает ctx, в котором точно есть id запроса, и сам id
+func ensureRequestID(ctx context.Context) (context.Context, string) {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return ctx, id
+	}
+	id := newRequestID()
+	return ContextWithRequestID(ctx, id), id
+}
+
+func newRequestID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// RequestIDFromError - id запроса, на котором случилась ошибка, или пустая строка,
+// если запрос до внешней системы не дошёл
+func RequestIDFromError(err error) string {
+	if id := requestIDField(err); id != nil {
+		return *id
+	}
+	return ""
+}
+
+// withRequestID проставляет id отправленного запроса в ошибку, если внешняя система его не вернула
+func withRequestID(err error, id string) error {
+	if field := requestIDField(err); field != nil && *field == "" {
+		*field = id
+	}
+	return err
+}
+
+// requestIDField - поле RequestID ошибки клиента в цепочке err или nil, если такой ошибки в ней нет
+func requestIDField(err error) *string {
+	var searchErr *SearchError
+	var timeoutErr *TimeoutError
+	var transportErr *TransportError
+	var decodeErr *DecodeError
+	var tooLarge *BodyTooLargeError
+	var notFound *UserNotFoundError
+	switch {
+	case errors.As(err, &searchErr):
+		return &searchErr.RequestID
+	case errors.As(err, &timeoutErr):
+		return &timeoutErr.RequestID
+	case errors.As(err, &transportErr):
+		return &transportErr.RequestID
+	case errors.As(err, &decodeErr):
+		return &decodeErr.RequestID
+	case errors.As(err, &tooLarge):
+		return &tooLarge.RequestID
+	case errors.As(err, &notFound):
+		return &notFound.RequestID
+	}
+	return nil
+}
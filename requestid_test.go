@@ -0,0 +1,200 @@
+package lesson4
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lesson4/fakesearch"
+)
+
+func TestRequestIDFromContext(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Request-ID")
+		SearchServer(w, r)
+	}))
+	defer ts.Close()
+
+	client := &SearchClient{AccessToken: "TestToken", URL: ts.URL}
+	ctx := ContextWithRequestID(context.Background(), "incoming-42")
+	if _, err := client.FindUsersContext(ctx, SearchRequest{Limit: 1}); err != nil {
+		t.Fatalf("test failed - %s", err)
+	}
+	if got != "incoming-42" {
+		t.Errorf("test failed - expected id from context, got %q", got)
+	}
+
+	client.FindUsers(SearchRequest{Limit: 1})
+	if got == "" || got == "incoming-42" {
+		t.Errorf("test failed - client must generate new id, got %q", got)
+	}
+}
+
+func TestRequestIDInErrors(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	client := &SearchClient{AccessToken: "WrongToken", URL: ts.URL}
+	ctx := ContextWithRequestID(context.Background(), "bad-token-1")
+	_, err := client.FindUsersContext(ctx, SearchRequest{Limit: 1})
+	if RequestIDFromError(err) != "bad-token-1" {
+		t.Errorf("test failed - expected id in error, got %q", RequestIDFromError(err))
+	}
+
+	_, err = client.GetUser(1)
+	if RequestIDFromError(err) == "" {
+		t.Errorf("test failed - GetUser error without id")
+	}
+
+	if RequestIDFromError(&ValidationError{}) != "" {
+		t.Errorf("test failed - validation error was not sent and has no id")
+	}
+}
+
+func TestRequestIDWithoutServerID(t *testing.T) {
+	//старый сервер не знает про X-Request-ID и не кладёт его в тело ошибки
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	client := &SearchClient{AccessToken: "TestToken", URL: ts.URL}
+	ctx := ContextWithRequestID(context.Background(), "old-server")
+	_, err := client.FindUsersContext(ctx, SearchRequest{Limit: 1})
+	if RequestIDFromError(err) != "old-server" {
+		t.Errorf("test failed - expected id of sent request, got %q", RequestIDFromError(err))
+	}
+}
+
+func TestRequestIDTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Second)
+	}))
+	defer ts.Close()
+
+	client := &SearchClient{AccessToken: "TestToken", URL: ts.URL}
+	ctx := ContextWithRequestID(context.Background(), "slow-1")
+	_, err := client.FindUsersContext(ctx, SearchRequest{Limit: 1})
+	if RequestIDFromError(err) != "slow-1" {
+		t.Errorf("test failed - expected id in timeout error, got %q", RequestIDFromError(err))
+	}
+}
+
+// TestRequestIDTypedErrors - id отправленного запроса есть в каждой ошибке, случившейся после отправки
+func TestRequestIDTypedErrors(t *testing.T) {
+	var transportErr *TransportError
+	var decodeErr *DecodeError
+	var tooLarge *BodyTooLargeError
+	cases := []struct {
+		name   string
+		script func(next *fakesearch.Response)
+		typed  func(err error) bool
+	}{
+		{"dropped", func(next *fakesearch.Response) { next.DropConnection() },
+			func(err error) bool { return errors.As(err, &transportErr) && !transportErr.Read }},
+		{"truncated", func(next *fakesearch.Response) { next.Body(`[{"id":1}]`).PartialBody(3) },
+			func(err error) bool { return errors.As(err, &transportErr) && transportErr.Read }},
+		{"bad result", func(next *fakesearch.Response) { next.Body(`{"id":1}`) },
+			func(err error) bool { return errors.As(err, &decodeErr) && decodeErr.Body == "result" }},
+		{"bad error", func(next *fakesearch.Response) { next.Status(http.StatusBadRequest).Body(`oops`) },
+			func(err error) bool { return errors.As(err, &decodeErr) && decodeErr.Body == "error" }},
+		{"too large", func(next *fakesearch.Response) { next.Body(strings.Repeat(" ", 100) + "[]") },
+			func(err error) bool { return errors.As(err, &tooLarge) }},
+	}
+	for _, c := range cases {
+		ts := fakesearch.New(t)
+		c.script(ts.Next())
+		client := &SearchClient{AccessToken: "TestToken", URL: ts.URL, MaxBodySize: 50}
+		ctx := ContextWithRequestID(context.Background(), "typed-1")
+		_, err := client.FindUsersContext(ctx, SearchRequest{Limit: 1})
+		if !c.typed(err) || RequestIDFromError(err) != "typed-1" {
+			t.Errorf("%s: test failed - expected typed error with id, got %T %v, id %q", c.name, err, err, RequestIDFromError(err))
+		}
+	}
+
+	ts := fakesearch.New(t)
+	ts.Next().Body(`[1]`)
+	client := &SearchClient{AccessToken: "TestToken", URL: ts.URL, APIVersion: APIVersionV2}
+	_, err := client.GetUser(1)
+	if !errors.As(err, &decodeErr) || RequestIDFromError(err) != ts.LastRequest().Header.Get("X-Request-ID") {
+		t.Errorf("test failed - GetUser decode error must keep id, got %v, id %q", err, RequestIDFromError(err))
+	}
+}
+
+// TestRequestIDUserNotFound - у ненайденных пользователей тоже есть id запроса, с ответом 404 и без него
+func TestRequestIDUserNotFound(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	var notFound *UserNotFoundError
+	client := &SearchClient{AccessToken: "TestToken", URL: ts.URL}
+	_, err := client.GetUser(1000)
+	if !errors.As(err, &notFound) || RequestIDFromError(err) == "" {
+		t.Errorf("test failed - GetUser not found error must keep id, got %v, id %q", err, RequestIDFromError(err))
+	}
+
+	fake := fakesearch.New(t)
+	fake.Next().Users(User{Id: 1, Name: "Mayer Hilda"})
+	client = &SearchClient{AccessToken: "TestToken", URL: fake.URL}
+	users, err := client.GetUsers(1, 1000)
+	if len(users) != 1 || !errors.As(err, &notFound) || RequestIDFromError(err) != fake.LastRequest().Header.Get("X-Request-ID") {
+		t.Errorf("test failed - partial GetUsers error must keep sent id, got %v, id %q", err, RequestIDFromError(err))
+	}
+}
+
+func TestServerEchoesRequestID(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL+"/v1/users/search?limit=1", nil)
+	req.Header.Set("AccessToken", "WrongToken")
+	req.Header.Set("X-Request-ID", "echo-1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("test failed - %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	errResp := SearchErrorResponse{}
+	json.Unmarshal(body, &errResp)
+	if resp.Header.Get("X-Request-ID") != "echo-1" || errResp.RequestID != "echo-1" {
+		t.Errorf("test failed - expected echo-1 in header and body, got %q and %q", resp.Header.Get("X-Request-ID"), errResp.RequestID)
+	}
+
+	resp, err = http.Get(ts.URL + "/openapi.json")
+	if err != nil {
+		t.Fatalf("test failed - %s", err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("X-Request-ID") == "" {
+		t.Errorf("test failed - server must generate id when client sends none")
+	}
+}
+
+func TestRequestIDInLogs(t *testing.T) {
+	var serverOut, clientOut bytes.Buffer
+	serverLogger = slog.New(slog.NewJSONHandler(&serverOut, nil))
+	defer func() { serverLogger = nil }()
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	client := &SearchClient{AccessToken: "TestToken", URL: ts.URL, Logger: slog.New(slog.NewJSONHandler(&clientOut, nil))}
+	client.FindUsers(SearchRequest{Limit: 1})
+
+	clientLines, serverLines := logLines(t, &clientOut), logLines(t, &serverOut)
+	if len(clientLines) != 1 || len(serverLines) != 1 {
+		t.Fatalf("test failed - expected one line on each side, got %d and %d", len(clientLines), len(serverLines))
+	}
+	if clientLines[0]["request_id"] == "" || clientLines[0]["request_id"] != serverLines[0]["request_id"] {
+		t.Errorf("test failed - client and server ids differ: %v and %v", clientLines[0]["request_id"], serverLines[0]["request_id"])
+	}
+}
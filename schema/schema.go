@@ -0,0 +1,124 @@
+// Package schema - типы, которыми обмениваются клиент поиска и внешняя система.
+// И клиент, и сервер берут их отсюда, чтобы формат ответа не разъехался
+package schema
+
+import (
+	"encoding/json"
+	"encoding/xml"
+)
+
+// Version - версия схемы. Меняется при любом несовместимом изменении json-представления типов ниже
+const Version = 2
+
+// медиа-типы ответов поиска, между которыми внешняя система выбирает по заголовку Accept
+const (
+	MediaTypeJSON = "application/json"
+	MediaTypeV2   = "application/vnd.usersearch.v2+json"
+)
+
+// коды ошибок в SearchErrorResponse.Error
+const (
+	CodeBadAccessToken       = "ErrorBadAccessToken"
+	CodeBadLimit             = "ErrorBadLimit"
+	CodeBadOffset            = "ErrorBadOffset"
+	CodeBadOrderBy           = "ErrorBadOrderBy"
+	CodeBadOrderField        = "ErrorBadOrderField"
+	CodeBadBody              = "ErrorBadBody"
+	CodeBadId                = "ErrorBadId"
+	CodeUserNotFound         = "ErrorUserNotFound"
+	CodeMethodNotAllowed     = "ErrorMethodNotAllowed"
+	CodeNotAcceptable        = "ErrorNotAcceptable"
+	CodeUnsupportedMediaType = "ErrorUnsupportedMediaType"
+	CodeUnavailable          = "ErrorUnavailable"
+)
+
+// User - пользователь в ответе поиска. Name - это last_name + first_name из Row
+type User struct {
+	Id     int    `json:"id"`
+	Name   string `json:"name"`
+	Age    int    `json:"age"`
+	About  string `json:"about"`
+	Gender string `json:"gender"`
+}
+
+func (cur *User) Equals(compareTo *User) bool {
+	if cur == compareTo {
+		return true
+	}
+
+	if cur.Id != compareTo.Id || cur.Age != compareTo.Age || cur.About != compareTo.About || cur.Name != compareTo.Name || cur.Gender != compareTo.Gender {
+		return false
+	}
+	return true
+}
+
+type SearchRequest struct {
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"` // Можно учесть после сортировки
+	Query      string `json:"query"`  // подстрока в 1 из полей
+	OrderField string `json:"order_field"`
+	// -1 по убыванию, 0 как встретилось, 1 по возрастанию
+	OrderBy int `json:"order_by"`
+}
+
+// SearchResponse - страница результатов. В API v2 внешняя система отдаёт его как есть
+type SearchResponse struct {
+	Users    []User `json:"users"`
+	NextPage bool   `json:"next_page"`
+}
+
+// FieldError - ошибка в одном параметре запроса
+type FieldError struct {
+	// имя параметра так, как оно уходит в query string
+	Field string `json:"field"`
+	// код ошибки, один из Code*
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// SearchErrorResponse - тело ответа внешней системы на любую ошибку
+type SearchErrorResponse struct {
+	// код ошибки, один из Code*
+	Error string `json:"error"`
+	// описание для человека
+	Message string `json:"message"`
+	// параметр запроса, из-за которого ошибка, если она про конкретный параметр
+	Field string `json:"field"`
+	// ошибки по каждому параметру, если запрос не прошёл проверку. Первая из них дублируется в Error, Message и Field
+	Errors []FieldError `json:"errors,omitempty"`
+	// идентификатор запроса, по которому ошибку можно найти в логах внешней системы
+	RequestID string `json:"request_id"`
+}
+
+// SearchBatchResult - ответ внешней системы на один запрос из пачки: статус и тело, как у обычного запроса
+type SearchBatchResult struct {
+	StatusCode int             `json:"status_code"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// Root - корень dataset.xml
+type Root struct {
+	XMLName xml.Name `xml:"root"`
+	Rows    []Row    `xml:"row"`
+}
+
+// Row - запись о пользователе в dataset.xml. В json-датасете у полей те же имена, что и в xml
+type Row struct {
+	Id            int    `xml:"id" json:"id"`
+	Guid          string `xml:"guid" json:"guid"`
+	IsActive      string `xml:"isActive" json:"isActive"`
+	Balance       string `xml:"balance" json:"balance"`
+	Picture       string `xml:"picture" json:"picture"`
+	Age           int    `xml:"age" json:"age"`
+	EyeColor      string `xml:"eyeColor" json:"eyeColor"`
+	FirstName     string `xml:"first_name" json:"first_name"`
+	LastName      string `xml:"last_name" json:"last_name"`
+	Gender        string `xml:"gender" json:"gender"`
+	Company       string `xml:"company" json:"company"`
+	Email         string `xml:"email" json:"email"`
+	Phone         string `xml:"phone" json:"phone"`
+	Address       string `xml:"address" json:"address"`
+	About         string `xml:"about" json:"about"`
+	Registered    string `xml:"registered" json:"registered"`
+	FavoriteFruit string `xml:"favoriteFruit" json:"favoriteFruit"`
+}
@@ -0,0 +1,81 @@
+package schema
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"reflect"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	values := []interface{}{
+		&User{Id: 1, Name: "Mayer Hilda", Age: 21, About: "Sit commodo", Gender: "female"},
+		&SearchRequest{Limit: 10, Offset: 5, Query: "Hilda", OrderField: "Age", OrderBy: -1},
+		&SearchResponse{Users: []User{{Id: 1, Name: "Mayer Hilda"}}, NextPage: true},
+		&SearchErrorResponse{
+			Error:     CodeBadLimit,
+			Message:   "limit must be > 0",
+			Field:     "limit",
+			Errors:    []FieldError{{Field: "limit", Code: CodeBadLimit, Message: "limit must be > 0"}},
+			RequestID: "abc",
+		},
+		&SearchBatchResult{StatusCode: 200, Body: json.RawMessage(`[{"id":1}]`)},
+	}
+	for _, value := range values {
+		data, err := json.Marshal(value)
+		if err != nil {
+			t.Errorf("%T: cant marshal: %v", value, err)
+			continue
+		}
+		decoded := reflect.New(reflect.TypeOf(value).Elem()).Interface()
+		if err = json.Unmarshal(data, decoded); err != nil {
+			t.Errorf("%T: cant unmarshal: %v", value, err)
+			continue
+		}
+		if !reflect.DeepEqual(value, decoded) {
+			t.Errorf("%T: round trip mismatch\nGot:\n%+v\nExpected:\n%+v", value, decoded, value)
+		}
+	}
+}
+
+// json-имена полей - это контракт с внешней системой, менять их можно только вместе с Version
+func TestWireNames(t *testing.T) {
+	cases := []struct {
+		value    interface{}
+		expected string
+	}{
+		{User{Id: 1, Name: "n", Age: 2, About: "a", Gender: "g"}, `{"id":1,"name":"n","age":2,"about":"a","gender":"g"}`},
+		{SearchRequest{Limit: 1, Offset: 2, Query: "q", OrderField: "Id", OrderBy: 1}, `{"limit":1,"offset":2,"query":"q","order_field":"Id","order_by":1}`},
+		{SearchResponse{Users: []User{}, NextPage: true}, `{"users":[],"next_page":true}`},
+		{SearchErrorResponse{Error: CodeBadId, Message: "m", Field: "id", RequestID: "r"}, `{"error":"ErrorBadId","message":"m","field":"id","request_id":"r"}`},
+		{SearchBatchResult{StatusCode: 404, Body: json.RawMessage(`{}`)}, `{"status_code":404,"body":{}}`},
+	}
+	for _, c := range cases {
+		data, err := json.Marshal(c.value)
+		if err != nil || string(data) != c.expected {
+			t.Errorf("%T: wrong json %s, expected %s", c.value, data, c.expected)
+		}
+	}
+}
+
+func TestRowXML(t *testing.T) {
+	data := `<root><row><id>7</id><age>30</age><first_name>Boyd</first_name><last_name>Wolf</last_name><about>text</about></row></root>`
+	root := Root{}
+	if err := xml.Unmarshal([]byte(data), &root); err != nil {
+		t.Errorf("cant unmarshal: %v", err)
+		return
+	}
+	expected := []Row{{Id: 7, Age: 30, FirstName: "Boyd", LastName: "Wolf", About: "text"}}
+	if !reflect.DeepEqual(root.Rows, expected) {
+		t.Errorf("wrong rows %+v", root.Rows)
+	}
+}
+
+func TestUserEquals(t *testing.T) {
+	a := &User{Id: 1, Name: "n"}
+	b := &User{Id: 1, Name: "n"}
+	c := &User{Id: 2, Name: "n"}
+	if !a.Equals(a) || !a.Equals(b) || a.Equals(c) {
+		t.Error("wrong Equals")
+	}
+}
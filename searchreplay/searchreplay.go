@@ -0,0 +1,285 @@
+// Package searchreplay записывает обмены SearchClient с внешней системой в golden-файл
+// и воспроизводит их в тестах без сети:
+//
+//	client := &SearchClient{
+//		URL:        url,
+//		HTTPClient: &http.Client{Transport: searchreplay.Transport(t, "testdata/search.golden.json", nil)},
+//	}
+//
+// Обычно Transport отвечает из файла, а с флагом -replay-update ходит в живую систему
+// и перезаписывает файл. Запрос сопоставляется с записью по методу, пути, параметрам
+// query string без учёта порядка и json-телу без учёта порядка ключей. Хост и заголовки
+// не учитываются и в файл не пишутся, поэтому AccessToken туда не попадает
+package searchreplay
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var update = flag.Bool("replay-update", false, "перезаписать golden-файлы searchreplay по живой внешней системе")
+
+// Updating - запущены ли тесты с -replay-update, то есть нужна ли живая внешняя система
+func Updating() bool {
+	return *update
+}
+
+// Exchange - один записанный запрос и ответ на него
+type Exchange struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// параметры query string в нормализованном виде
+	Query string `json:"query"`
+	// тело запроса, json нормализован
+	Body        string `json:"body,omitempty"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Response    string `json:"response"`
+}
+
+func (e Exchange) key() string {
+	return e.Method + " " + e.Path + "?" + e.Query + " " + e.Body
+}
+
+// Transport - RoundTripper для тестов: воспроизводит path, а с -replay-update записывает его заново через live.
+// live nil - http.DefaultTransport. Любой запрос без записи роняет тест
+func Transport(tb testing.TB, path string, live http.RoundTripper) http.RoundTripper {
+	tb.Helper()
+	if *update {
+		recorder := NewRecorder(live)
+		tb.Cleanup(func() {
+			if err := recorder.Save(path); err != nil {
+				tb.Errorf("searchreplay: %s", err)
+			}
+		})
+		return recorder
+	}
+
+	replayer, err := Load(path)
+	if err != nil {
+		tb.Fatalf("searchreplay: %s (run with -replay-update to record)", err)
+	}
+	replayer.tb = tb
+	return replayer
+}
+
+// Recorder пропускает запросы в живую систему и запоминает обмены
+type Recorder struct {
+	live http.RoundTripper
+
+	mu        sync.Mutex
+	exchanges []Exchange
+}
+
+func NewRecorder(live http.RoundTripper) *Recorder {
+	if live == nil {
+		live = http.DefaultTransport
+	}
+	return &Recorder{live: live, exchanges: []Exchange{}}
+}
+
+func (rec *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	exchange, err := requestExchange(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := rec.live.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	exchange.Status = resp.StatusCode
+	exchange.ContentType = resp.Header.Get("Content-Type")
+	exchange.Response = string(body)
+	rec.mu.Lock()
+	rec.exchanges = append(rec.exchanges, exchange)
+	rec.mu.Unlock()
+	return resp, nil
+}
+
+// Exchanges - записанные обмены в порядке запросов
+func (rec *Recorder) Exchanges() []Exchange {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]Exchange(nil), rec.exchanges...)
+}
+
+// Save пишет записанные обмены в path
+func (rec *Recorder) Save(path string) error {
+	//без экранирования & и <, чтобы файл можно было читать в диффах
+	data := &bytes.Buffer{}
+	encoder := json.NewEncoder(data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(rec.Exchanges()); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data.Bytes(), 0644)
+}
+
+// Replayer отвечает записанными ответами. Одинаковые запросы получают записи в том порядке, в каком были записаны,
+// а когда записи кончаются - последнюю из них
+type Replayer struct {
+	tb testing.TB
+
+	mu        sync.Mutex
+	exchanges []Exchange
+	used      []bool
+}
+
+// Load читает golden-файл
+func Load(path string) (*Replayer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	exchanges := []Exchange{}
+	if err = json.Unmarshal(data, &exchanges); err != nil {
+		return nil, fmt.Errorf("cant unpack %s: %s", path, err)
+	}
+	return NewReplayer(exchanges), nil
+}
+
+func NewReplayer(exchanges []Exchange) *Replayer {
+	return &Replayer{exchanges: exchanges, used: make([]bool, len(exchanges))}
+}
+
+func (rep *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	exchange, err := requestExchange(req)
+	if err != nil {
+		return nil, err
+	}
+
+	found, ok := rep.match(exchange.key())
+	if !ok {
+		err = fmt.Errorf("searchreplay: no recorded exchange for %s", exchange.key())
+		if rep.tb != nil {
+			rep.tb.Error(err)
+		}
+		return nil, err
+	}
+
+	header := http.Header{}
+	if found.ContentType != "" {
+		header.Set("Content-Type", found.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", found.Status, http.StatusText(found.Status)),
+		StatusCode:    found.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(strings.NewReader(found.Response)),
+		ContentLength: int64(len(found.Response)),
+		Request:       req,
+	}, nil
+}
+
+func (rep *Replayer) match(key string) (Exchange, bool) {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+
+	last := -1
+	for i, exchange := range rep.exchanges {
+		if exchange.key() != key {
+			continue
+		}
+		if !rep.used[i] {
+			rep.used[i] = true
+			return exchange, true
+		}
+		last = i
+	}
+	if last < 0 {
+		return Exchange{}, false
+	}
+	return rep.exchanges[last], true
+}
+
+// Unused - записи, на которые не пришло ни одного запроса. Обычно значит, что golden-файл устарел
+func (rep *Replayer) Unused() []Exchange {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	unused := []Exchange{}
+	for i, exchange := range rep.exchanges {
+		if !rep.used[i] {
+			unused = append(unused, exchange)
+		}
+	}
+	return unused
+}
+
+// requestExchange - запрос в нормализованном виде, без ответа
+func requestExchange(req *http.Request) (Exchange, error) {
+	exchange := Exchange{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Query:  NormalizeQuery(req.URL.Query()),
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return exchange, nil
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return exchange, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	exchange.Body = normalizeBody(body)
+	return exchange, nil
+}
+
+// NormalizeQuery - параметры, отсортированные по имени, а значения одного параметра - по значению
+func NormalizeQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := []string{}
+	for _, key := range keys {
+		vals := append([]string(nil), values[key]...)
+		sort.Strings(vals)
+		for _, val := range vals {
+			parts = append(parts, url.QueryEscape(key)+"="+url.QueryEscape(val))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// normalizeBody переупаковывает json, чтобы порядок ключей и пробелы не мешали сопоставлению. Не json оставляется как есть
+func normalizeBody(body []byte) string {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return string(body)
+	}
+	normalized, err := json.Marshal(value)
+	if err != nil {
+		return string(body)
+	}
+	return string(normalized)
+}
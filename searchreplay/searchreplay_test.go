@@ -0,0 +1,117 @@
+package searchreplay
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// recordingTB запоминает ошибки вместо того, чтобы ронять тест
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (tb *recordingTB) Error(args ...interface{}) {
+	tb.errors = append(tb.errors, fmt.Sprint(args...))
+}
+
+func TestNormalizeQuery(t *testing.T) {
+	first, _ := url.ParseQuery("query=&order_by=1&limit=2&id=3&id=1")
+	second, _ := url.ParseQuery("id=1&id=3&limit=2&order_by=1&query=")
+	if NormalizeQuery(first) != NormalizeQuery(second) {
+		t.Errorf("test failed - %s != %s", NormalizeQuery(first), NormalizeQuery(second))
+	}
+	if NormalizeQuery(first) != "id=1&id=3&limit=2&order_by=1&query=" {
+		t.Errorf("test failed - wrong normalized query %s", NormalizeQuery(first))
+	}
+}
+
+func TestNormalizeBody(t *testing.T) {
+	if normalizeBody([]byte(`{ "offset": 0, "limit": 2 }`)) != normalizeBody([]byte(`{"limit":2,"offset":0}`)) {
+		t.Errorf("test failed - json key order must not matter")
+	}
+	if normalizeBody([]byte("not json")) != "not json" {
+		t.Errorf("test failed - not json must stay as is")
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"call":%d,"query":%q}`, calls, r.URL.Query().Get("query"))
+	}))
+
+	recorder := NewRecorder(nil)
+	client := &http.Client{Transport: recorder}
+	for _, query := range []string{"?query=a&limit=1", "?query=a&limit=1", "?query=b&limit=1"} {
+		resp, err := client.Get(ts.URL + "/users" + query)
+		if err != nil {
+			t.Fatalf("test failed - %s", err)
+		}
+		resp.Body.Close()
+	}
+	req, _ := http.NewRequest("POST", ts.URL+"/users", strings.NewReader(`{"query":"c","limit":1}`))
+	resp, _ := client.Do(req)
+	resp.Body.Close()
+	ts.Close()
+
+	path := filepath.Join(t.TempDir(), "exchanges.json")
+	if err := recorder.Save(path); err != nil {
+		t.Fatalf("test failed - %s", err)
+	}
+	replayer, err := Load(path)
+	if err != nil {
+		t.Fatalf("test failed - %s", err)
+	}
+
+	client = &http.Client{Transport: replayer}
+	expected := []struct {
+		method, url, body, response string
+	}{
+		{"GET", "http://offline/users?limit=1&query=a", "", `{"call":1,"query":"a"}`},
+		{"GET", "http://offline/users?limit=1&query=b", "", `{"call":3,"query":"b"}`},
+		{"GET", "http://offline/users?limit=1&query=a", "", `{"call":2,"query":"a"}`},
+		{"GET", "http://offline/users?query=a&limit=1", "", `{"call":2,"query":"a"}`},
+		{"POST", "http://offline/users", `{"limit":1, "query":"c"}`, `{"call":4,"query":""}`},
+	}
+	for _, c := range expected {
+		req, _ := http.NewRequest(c.method, c.url, strings.NewReader(c.body))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("test failed - %s", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != c.response || resp.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("test failed - %s %s: expected %s, got %s", c.method, c.url, c.response, body)
+		}
+	}
+	if len(replayer.Unused()) != 0 {
+		t.Errorf("test failed - all exchanges must be used, unused %v", replayer.Unused())
+	}
+}
+
+func TestReplayUnmatched(t *testing.T) {
+	tb := &recordingTB{TB: t}
+	replayer := NewReplayer([]Exchange{{Method: "GET", Path: "/users", Query: "limit=1", Status: 200, Response: "[]"}})
+	replayer.tb = tb
+
+	_, err := (&http.Client{Transport: replayer}).Get("http://offline/users?limit=2")
+	if err == nil || !strings.Contains(err.Error(), "no recorded exchange for GET /users?limit=2") {
+		t.Errorf("test failed - expected unmatched error, got %v", err)
+	}
+	if len(tb.errors) != 1 {
+		t.Errorf("test failed - unmatched request must fail the test, got %v", tb.errors)
+	}
+	if len(replayer.Unused()) != 1 {
+		t.Errorf("test failed - exchange must stay unused")
+	}
+}
@@ -0,0 +1,279 @@
+package lesson4
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// DefaultMaxBodySize - сколько байт ответа клиент читает, если SearchClient.MaxBodySize не задан
+const DefaultMaxBodySize = 32 << 20
+
+// ErrBodyTooLarge - ответ внешней системы больше SearchClient.MaxBodySize
+var ErrBodyTooLarge = errors.New("response body too large")
+
+// BodyTooLargeError - ответ длиннее Limit байт. Чтение обрывается на лимите, errors.Is(err, ErrBodyTooLarge) для неё истинно
+type BodyTooLargeError struct {
+	Limit     int64
+	RequestID string
+}
+
+func (e *BodyTooLargeError) Error() string {
+	return fmt.Sprintf("response body is larger than %d bytes", e.Limit)
+}
+
+func (e *BodyTooLargeError) Unwrap() error {
+	return ErrBodyTooLarge
+}
+
+func (srv *SearchClient) maxBodySize() int64 {
+	if srv.MaxBodySize == 0 {
+		return DefaultMaxBodySize
+	}
+	return srv.MaxBodySize
+}
+
+// bodyReader читает тело ответа не дальше лимита и запоминает ошибку чтения,
+// чтобы после разбора json отличить оборванное тело от битого json
+type bodyReader struct {
+	r io.Reader
+	// сколько ещё можно прочитать, отрицательное - без ограничения
+	left  int64
+	limit int64
+	err   error
+}
+
+func (srv *SearchClient) newBodyReader(body io.Reader) *bodyReader {
+	limit := srv.maxBodySize()
+	return &bodyReader{r: body, left: limit, limit: limit}
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.limit >= 0 {
+		if b.left == 0 {
+			//тело закончилось ровно на лимите, только если дальше сразу EOF
+			n, err := b.r.Read(make([]byte, 1))
+			switch {
+			case n > 0 || err == nil:
+				b.err = &BodyTooLargeError{Limit: b.limit}
+			case err != io.EOF:
+				b.err = err
+			default:
+				return 0, io.EOF
+			}
+			return 0, b.err
+		}
+		if int64(len(p)) > b.left {
+			p = p[:b.left]
+		}
+	}
+	n, err := b.r.Read(p)
+	b.left -= int64(n)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// check - ошибка чтения, если она была. Без неё разбор json упал на самом json
+func (b *bodyReader) check(target string) error {
+	if b.err == nil {
+		return nil
+	}
+	var tooLarge *BodyTooLargeError
+	if errors.As(b.err, &tooLarge) {
+		return tooLarge
+	}
+	return readError(b.err, target)
+}
+
+// readAll - всё тело целиком, для ответов с ошибкой и ручек, которые не разбирают тело потоком
+func (b *bodyReader) readAll(target string) ([]byte, error) {
+	body, err := ioutil.ReadAll(b)
+	if err != nil {
+		return nil, b.check(target)
+	}
+	return body, nil
+}
+
+// FindUsersEach - FindUsersContext, который не собирает страницу в памяти: каждый пользователь
+// уходит в fn, как только разобран. Нужно для очень больших страниц. Возвращает, есть ли следующая страница.
+// Ошибка fn прерывает чтение ответа и возвращается как есть, пользователи до неё уже отданы
+func (srv *SearchClient) FindUsersEach(ctx context.Context, req SearchRequest, fn func(User) error) (nextPage bool, err error) {
+	ctx, requestID := ensureRequestID(ctx)
+	if srv.Logger != nil {
+		started := time.Now()
+		defer func() { srv.logFindUsers(req, requestID, started, err) }()
+	}
+	if srv.Metrics != nil {
+		done := srv.Metrics.start()
+		defer func() { done(err) }()
+	}
+
+	ctx, span := srv.Tracer.Start(ctx, "FindUsers")
+	span.SetAttribute("request_id", requestID)
+	span.SetAttribute("limit", req.Limit)
+	span.SetAttribute("offset", req.Offset)
+	span.SetAttribute("order_field", req.OrderField)
+	span.SetAttribute("order_by", req.OrderBy)
+	defer func() {
+		span.SetError(err)
+		span.Finish()
+	}()
+
+	if srv.Limiter != nil {
+		release, err := srv.Limiter.Acquire(ctx)
+		if err != nil {
+			return false, err
+		}
+		defer release()
+	}
+
+	nextPage, err = srv.findUsers(ctx, req, fn)
+	return nextPage, withRequestID(err, requestID)
+}
+
+// errStop - ошибка fn, её нельзя путать с ошибками разбора
+type errStop struct {
+	err error
+}
+
+func (e errStop) Error() string {
+	return e.err.Error()
+}
+
+// streamUsers разбирает массив пользователей. В v1 внешняя система отдаёт на одну запись больше
+// размера страницы req.Limit, эта запись означает следующую страницу и в fn не уходит. Поэтому запись
+// с номером req.Limit придерживается, пока не станет ясно, последняя ли она. Нулевой req.Limit - лишней записи нет
+func streamUsers(decoder *json.Decoder, req SearchRequest, fn func(User) error) (bool, error) {
+	if token, err := decoder.Token(); err != nil {
+		return false, err
+	} else if token == nil {
+		//null - пустой ответ
+		return false, nil
+	} else if token != json.Delim('[') {
+		return false, fmt.Errorf("expected array, got %v", token)
+	}
+
+	count := 0
+	var pending *User
+	for decoder.More() {
+		user := User{}
+		if err := decoder.Decode(&user); err != nil {
+			return false, err
+		}
+		count++
+		if pending != nil {
+			if err := fn(*pending); err != nil {
+				return false, errStop{err}
+			}
+			pending = nil
+		}
+		if req.Limit <= 0 || count < req.Limit {
+			if err := fn(user); err != nil {
+				return false, errStop{err}
+			}
+		} else {
+			pending = &user
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return false, err
+	}
+
+	if pending != nil && count == req.Limit {
+		return true, nil
+	}
+	if pending != nil {
+		if err := fn(*pending); err != nil {
+			return false, errStop{err}
+		}
+	}
+	return false, nil
+}
+
+// streamEnvelope разбирает ответ v2 - SearchResponse, где NextPage считает сервер
+func streamEnvelope(decoder *json.Decoder, fn func(User) error) (bool, error) {
+	if token, err := decoder.Token(); err != nil {
+		return false, err
+	} else if token != json.Delim('{') {
+		return false, fmt.Errorf("expected object, got %v", token)
+	}
+
+	nextPage := false
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return false, err
+		}
+		key, _ := token.(string)
+		switch {
+		case strings.EqualFold(key, "users"):
+			if _, err = streamUsers(decoder, SearchRequest{}, fn); err != nil {
+				return false, err
+			}
+		case strings.EqualFold(key, "next_page"):
+			if err = decoder.Decode(&nextPage); err != nil {
+				return false, err
+			}
+		default:
+			if err = decoder.Decode(&json.RawMessage{}); err != nil {
+				return false, err
+			}
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return false, err
+	}
+	return nextPage, nil
+}
+
+// decodeStream разбирает успешный ответ из body и проверяет, что после json ничего нет
+func decodeStream(body io.Reader, envelope bool, req SearchRequest, fn func(User) error) (bool, error) {
+	decoder := json.NewDecoder(body)
+	var nextPage bool
+	var err error
+	if envelope {
+		nextPage, err = streamEnvelope(decoder, fn)
+	} else {
+		nextPage, err = streamUsers(decoder, req, fn)
+	}
+	if err == nil {
+		if _, tokenErr := decoder.Token(); tokenErr != io.EOF {
+			err = fmt.Errorf("unexpected data after json")
+		}
+	}
+	if stop, ok := err.(errStop); ok {
+		return false, stop.err
+	}
+	if err != nil {
+		return false, &DecodeError{Body: "result", Err: err}
+	}
+	return nextPage, nil
+}
+
+// decodeResponse разбирает ответ внешней системы на подготовленный запрос req, когда тело уже прочитано
+func decodeResponse(statusCode int, body []byte, req SearchRequest) (*SearchResponse, error) {
+	if err := checkStatus(statusCode, body, req); err != nil {
+		return nil, err
+	}
+	result := &SearchResponse{Users: []User{}}
+	nextPage, err := decodeStream(bytes.NewReader(body), false, req, func(user User) error {
+		result.Users = append(result.Users, user)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.NextPage = nextPage
+	return result, nil
+}
@@ -0,0 +1,179 @@
+package lesson4
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lesson4/fakesearch"
+)
+
+func TestFindUsersBodyTooLarge(t *testing.T) {
+	ts := fakesearch.New(t)
+	users := []User{{Id: 1, Name: "Mayer Hilda"}, {Id: 2, Name: "Wolf Boyd"}}
+	body, _ := json.Marshal(users)
+	ts.Default().Users(users...)
+
+	client := SearchClient{AccessToken: "TestToken", URL: ts.URL, MaxBodySize: int64(len(body)) - 1}
+	result, err := client.FindUsers(SearchRequest{Limit: 5})
+	var tooLarge *BodyTooLargeError
+	if result != nil || !errors.Is(err, ErrBodyTooLarge) || !errors.As(err, &tooLarge) || tooLarge.Limit != int64(len(body))-1 {
+		t.Errorf("test failed - expected body too large, got %v, %v", result, err)
+	}
+	if outcome(err) != outcomeFatal {
+		t.Errorf("test failed - body too large must be fatal, got %s", outcome(err))
+	}
+
+	//тело ровно в лимит проходит
+	client.MaxBodySize = int64(len(body))
+	if result, err = client.FindUsers(SearchRequest{Limit: 5}); err != nil || len(result.Users) != 2 {
+		t.Errorf("test failed - body at limit must pass: %v, %v", result, err)
+	}
+
+	client.MaxBodySize = -1
+	if result, err = client.FindUsers(SearchRequest{Limit: 5}); err != nil || len(result.Users) != 2 {
+		t.Errorf("test failed - negative MaxBodySize must not limit: %v, %v", result, err)
+	}
+}
+
+func TestFindUsersErrorBodyTooLarge(t *testing.T) {
+	ts := fakesearch.New(t)
+	ts.Next().Error(http.StatusBadRequest, "ErrorBadLimit", strings.Repeat("x", 1000))
+
+	client := SearchClient{AccessToken: "TestToken", URL: ts.URL, MaxBodySize: 100}
+	if _, err := client.FindUsers(SearchRequest{Limit: 5}); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("test failed - expected body too large, got %v", err)
+	}
+
+	ts.Next().Body(strings.Repeat(" ", 1000))
+	if _, err := client.GetUser(1); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("test failed - GetUser must respect MaxBodySize too, got %v", err)
+	}
+}
+
+func TestFindUsersEach(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	for _, version := range []string{"", APIVersionV1, APIVersionV2} {
+		client := SearchClient{AccessToken: "TestToken", URL: ts.URL, APIVersion: version}
+		for _, req := range []SearchRequest{
+			{Limit: 3, Offset: 1, OrderField: "Id", OrderBy: 1},
+			{Limit: 10, Offset: 30},
+			{Limit: 5, Query: "SomeWrongParameter"},
+		} {
+			expected, err := client.FindUsers(req)
+			if err != nil {
+				t.Fatalf("%s: test failed - %s", version, err)
+			}
+			got := []User{}
+			nextPage, err := client.FindUsersEach(context.Background(), req, func(user User) error {
+				got = append(got, user)
+				return nil
+			})
+			if err != nil || nextPage != expected.NextPage || len(got) != len(expected.Users) {
+				t.Errorf("%s: test failed - %+v: %d users, next %v, %v; expected %+v", version, req, len(got), nextPage, err, expected)
+				continue
+			}
+			for i := range got {
+				if !got[i].Equals(&expected.Users[i]) {
+					t.Errorf("%s: test failed - user %d: %+v, expected %+v", version, i, got[i], expected.Users[i])
+				}
+			}
+		}
+	}
+}
+
+func TestFindUsersEachStop(t *testing.T) {
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	stop := errors.New("enough")
+	client := SearchClient{AccessToken: "TestToken", URL: ts.URL}
+	calls := 0
+	_, err := client.FindUsersEach(context.Background(), SearchRequest{Limit: 10}, func(user User) error {
+		calls++
+		if calls == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || calls != 2 {
+		t.Errorf("test failed - fn error must stop reading: %v after %d calls", err, calls)
+	}
+}
+
+// TestFindUsersEachStreams - первый пользователь доходит до fn, пока сервер ещё не дописал ответ
+func TestFindUsersEachStreams(t *testing.T) {
+	received := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"users":[{"id":1,"name":"Mayer Hilda"},`))
+		w.(http.Flusher).Flush()
+		select {
+		case <-received:
+		case <-time.After(time.Second):
+		}
+		w.Write([]byte(`{"id":2,"name":"Wolf Boyd"}],"next_page":true}`))
+	}))
+	defer ts.Close()
+
+	client := SearchClient{AccessToken: "TestToken", URL: ts.URL, APIVersion: APIVersionV2}
+	started := time.Now()
+	ids := []int{}
+	nextPage, err := client.FindUsersEach(context.Background(), SearchRequest{Limit: 2}, func(user User) error {
+		if user.Id == 1 {
+			close(received)
+		}
+		ids = append(ids, user.Id)
+		return nil
+	})
+	if err != nil || !nextPage || len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Errorf("test failed - %v, %v, %v", ids, nextPage, err)
+	}
+	if time.Since(started) >= time.Second {
+		t.Errorf("test failed - first user was not delivered before the body ended")
+	}
+}
+
+func TestFindUsersStreamBadBodies(t *testing.T) {
+	cases := []struct {
+		version string
+		body    string
+	}{
+		{"", `[{"id":1}] trailing`},
+		{"", `{"id":1}`},
+		{"", `[{"id":"one"}]`},
+		{"", `[{"id":1},`},
+		{APIVersionV2, `[{"id":1}]`},
+		{APIVersionV2, `{"users":[{"id":1}],"next_page":"yes"}`},
+		{APIVersionV2, `{"users":{"id":1}}`},
+	}
+	for _, c := range cases {
+		ts := fakesearch.New(t)
+		ts.Next().Header("Content-Type", "application/json").Body(c.body)
+		client := SearchClient{AccessToken: "TestToken", URL: ts.URL, APIVersion: c.version}
+		result, err := client.FindUsers(SearchRequest{Limit: 5})
+		if result != nil || err == nil || !strings.HasPrefix(err.Error(), "cant unpack result json") {
+			t.Errorf("%s %s: test failed - expected unpack error, got %v, %v", c.version, c.body, result, err)
+		}
+	}
+
+	//null и лишние поля разбираются, как раньше разбирал json.Unmarshal
+	ts := fakesearch.New(t)
+	ts.Next().Body(`null`)
+	ts.Next().Body(`{"users":null,"total":7,"next_page":false}`)
+	client := SearchClient{AccessToken: "TestToken", URL: ts.URL}
+	if result, err := client.FindUsers(SearchRequest{Limit: 5}); err != nil || result.Users == nil || len(result.Users) != 0 {
+		t.Errorf("test failed - null must be empty result: %v, %v", result, err)
+	}
+	client.APIVersion = APIVersionV2
+	if result, err := client.FindUsers(SearchRequest{Limit: 5}); err != nil || result.Users == nil || len(result.Users) != 0 {
+		t.Errorf("test failed - null users must be empty result: %v, %v", result, err)
+	}
+}
@@ -0,0 +1,277 @@
+package lesson4
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// трассировка в духе OpenTelemetry: спаны с родителями, заголовок traceparent из W3C Trace Context
+// и подключаемый экспортёр. Все методы можно вызывать на nil-спане и nil-трейсере, тогда ничего не пишется
+
+// заголовок, в котором контекст трассировки передаётся между клиентом и сервером
+const traceParentHeader = "traceparent"
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanContext - то, что уходит в traceparent: трасса и спан, от которого продолжать
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// TraceParent - значение заголовка traceparent, все спаны считаются сэмплированными
+func (sc SpanContext) TraceParent() string {
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-01"
+}
+
+// ParseTraceParent разбирает заголовок traceparent версии 00
+func ParseTraceParent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("bad traceparent %q", value)
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return sc, fmt.Errorf("bad traceparent %q", value)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, fmt.Errorf("bad traceparent %q: %s", value, err)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, fmt.Errorf("bad traceparent %q: %s", value, err)
+	}
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("bad traceparent %q: zero id", value)
+	}
+	return sc, nil
+}
+
+// Span - одна операция в трассе
+type Span struct {
+	Name     string
+	Context  SpanContext
+	ParentID SpanID
+	Start    time.Time
+	// время окончания, нулевое пока спан не закрыт
+	End        time.Time
+	Attributes map[string]string
+	// ошибка, с которой закончилась операция
+	Err error
+
+	tracer *Tracer
+	mu     sync.Mutex
+}
+
+// SetAttribute добавляет к спану пару ключ-значение
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Attributes[key] = fmt.Sprint(value)
+	s.mu.Unlock()
+}
+
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Err = err
+	s.mu.Unlock()
+}
+
+// Finish закрывает спан и отдаёт его экспортёру. Повторный вызов ничего не делает
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.End.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.End = time.Now()
+	s.mu.Unlock()
+	if s.tracer.exporter != nil {
+		s.tracer.exporter.ExportSpan(s)
+	}
+}
+
+func (s *Span) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// Exporter получает каждый закрытый спан. Должен выдерживать вызовы из нескольких горутин
+type Exporter interface {
+	ExportSpan(span *Span)
+}
+
+// InMemoryExporter складывает спаны в память, нужен в основном тестам
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *InMemoryExporter) ExportSpan(span *Span) {
+	e.mu.Lock()
+	e.spans = append(e.spans, span)
+	e.mu.Unlock()
+}
+
+// Spans - закрытые спаны в порядке закрытия
+func (e *InMemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	e.spans = nil
+	e.mu.Unlock()
+}
+
+// JSONExporter пишет каждый спан строкой json, например в файл или stderr
+type JSONExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{w: w}
+}
+
+func (e *JSONExporter) ExportSpan(span *Span) {
+	line := struct {
+		Name       string            `json:"name"`
+		TraceID    string            `json:"trace_id"`
+		SpanID     string            `json:"span_id"`
+		ParentID   string            `json:"parent_id,omitempty"`
+		Start      time.Time         `json:"start"`
+		DurationMs float64           `json:"duration_ms"`
+		Attributes map[string]string `json:"attributes,omitempty"`
+		Error      string            `json:"error,omitempty"`
+	}{
+		Name:       span.Name,
+		TraceID:    span.Context.TraceID.String(),
+		SpanID:     span.Context.SpanID.String(),
+		Start:      span.Start,
+		DurationMs: float64(span.Duration()) / float64(time.Millisecond),
+		Attributes: span.Attributes,
+	}
+	if span.ParentID != (SpanID{}) {
+		line.ParentID = span.ParentID.String()
+	}
+	if span.Err != nil {
+		line.Error = span.Err.Error()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	json.NewEncoder(e.w).Encode(line)
+}
+
+// Tracer создаёт спаны и отдаёт закрытые в exporter
+type Tracer struct {
+	exporter Exporter
+}
+
+// NewTracer - трассировщик с экспортёром exporter. С nil спаны создаются и traceparent уходит
+// во внешнюю систему, но закрытые спаны никуда не отдаются
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+type spanKey struct{}
+
+type remoteParentKey struct{}
+
+// Start открывает спан. Родитель - спан из ctx, а если его нет, контекст, пришедший в traceparent.
+// Возвращает ctx с новым спаном, чтобы от него можно было продолжать
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{Name: name, Start: time.Now(), Attributes: map[string]string{}, tracer: t}
+
+	parent := SpanContext{}
+	if parentSpan := SpanFromContext(ctx); parentSpan != nil {
+		parent = parentSpan.Context
+	} else if remote, ok := ctx.Value(remoteParentKey{}).(SpanContext); ok {
+		parent = remote
+	}
+	if parent.IsValid() {
+		span.Context.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+	} else {
+		rand.Read(span.Context.TraceID[:])
+	}
+	rand.Read(span.Context.SpanID[:])
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// StartSpan открывает дочерний спан тем же трейсером, что и спан в ctx. Без спана в ctx ничего не пишется
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name)
+}
+
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// InjectTraceParent кладёт контекст текущего спана в заголовки исходящего запроса
+func InjectTraceParent(ctx context.Context, header http.Header) {
+	if span := SpanFromContext(ctx); span != nil {
+		header.Set(traceParentHeader, span.Context.TraceParent())
+	}
+}
+
+// ExtractTraceParent достаёт контекст из заголовков входящего запроса, чтобы спаны сервера
+// попали в трассу клиента. Битый или отсутствующий заголовок просто начинает новую трассу
+func ExtractTraceParent(ctx context.Context, header http.Header) context.Context {
+	sc, err := ParseTraceParent(header.Get(traceParentHeader))
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteParentKey{}, sc)
+}
+
+// Middleware открывает спан на каждый запрос к next, продолжая трассу из traceparent
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := t.Start(ExtractTraceParent(r.Context(), r.Header), r.Method+" "+r.URL.Path)
+		defer span.Finish()
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		span.SetAttribute("http.status_code", sw.status)
+	})
+}
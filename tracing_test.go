@@ -0,0 +1,173 @@
+package lesson4
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTraceParentRoundTrip(t *testing.T) {
+	value := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceParent(value)
+	if err != nil {
+		t.Fatalf("test failed - %s", err)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("test failed - wrong ids %s %s", sc.TraceID, sc.SpanID)
+	}
+	if sc.TraceParent() != value {
+		t.Errorf("test failed - expected %s, got %s", value, sc.TraceParent())
+	}
+}
+
+func TestTraceParentInvalid(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-zzf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+	} {
+		if _, err := ParseTraceParent(value); err == nil {
+			t.Errorf("test failed - traceparent %q must be invalid", value)
+		}
+	}
+}
+
+func TestTracingPropagation(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	serverTracer = NewTracer(exporter)
+	defer func() { serverTracer = nil }()
+	ts := httptest.NewServer(searchMux())
+	defer ts.Close()
+
+	client := &SearchClient{AccessToken: "TestToken", URL: ts.URL, Tracer: NewTracer(exporter)}
+	if _, err := client.FindUsers(SearchRequest{Limit: 2, OrderField: "Age", OrderBy: 1, Query: "a"}); err != nil {
+		t.Fatalf("test failed - %s", err)
+	}
+
+	spans := map[string]*Span{}
+	for _, span := range exporter.Spans() {
+		spans[span.Name] = span
+	}
+	root, server := spans["FindUsers"], spans["GET /"]
+	if root == nil || server == nil {
+		t.Fatalf("test failed - no client or server span in %v", spans)
+	}
+	if root.ParentID != (SpanID{}) {
+		t.Errorf("test failed - FindUsers must be root span")
+	}
+	if server.ParentID != root.Context.SpanID {
+		t.Errorf("test failed - server span must continue FindUsers span")
+	}
+	if server.Attributes["http.status_code"] != "200" {
+		t.Errorf("test failed - server span status %q", server.Attributes["http.status_code"])
+	}
+	if root.Attributes["order_field"] != "Age" || root.Attributes["limit"] != "2" {
+		t.Errorf("test failed - FindUsers attributes %v", root.Attributes)
+	}
+
+	for _, phase := range []string{"load", "auth", "filter", "sort", "paginate", "encode"} {
+		span := spans[phase]
+		if span == nil {
+			t.Errorf("test failed - no %s span", phase)
+			continue
+		}
+		if span.Context.TraceID != root.Context.TraceID {
+			t.Errorf("test failed - %s span in another trace", phase)
+		}
+		if span.ParentID != server.Context.SpanID {
+			t.Errorf("test failed - %s span must be child of server span", phase)
+		}
+		if span.End.Before(span.Start) {
+			t.Errorf("test failed - %s span ends before start", phase)
+		}
+	}
+}
+
+func TestTracingError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(SearchServer))
+	defer ts.Close()
+
+	exporter := &InMemoryExporter{}
+	client := &SearchClient{AccessToken: "WrongToken", URL: ts.URL, Tracer: NewTracer(exporter)}
+	_, err := client.FindUsers(SearchRequest{Limit: 1})
+
+	spans := exporter.Spans()
+	if len(spans) != 1 || !errors.Is(spans[0].Err, ErrUnauthorized) || spans[0].Err != err {
+		t.Errorf("test failed - FindUsers span must keep error %v, got %v", err, spans)
+	}
+}
+
+func TestTracingDisabled(t *testing.T) {
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "noop")
+	span.SetAttribute("key", "value")
+	span.SetError(errors.New("noop"))
+	span.Finish()
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Errorf("test failed - nil tracer must not create spans")
+	}
+	if _, child := StartSpan(ctx, "child"); child != nil {
+		t.Errorf("test failed - child span without parent")
+	}
+
+	header := http.Header{}
+	InjectTraceParent(ctx, header)
+	if header.Get("traceparent") != "" {
+		t.Errorf("test failed - traceparent without span")
+	}
+}
+
+func TestTracingNilExporter(t *testing.T) {
+	var traceParent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceParent = r.Header.Get("traceparent")
+		SearchServer(w, r)
+	}))
+	defer ts.Close()
+
+	client := &SearchClient{AccessToken: "TestToken", URL: ts.URL, Tracer: NewTracer(nil)}
+	if _, err := client.FindUsers(SearchRequest{Limit: 2}); err != nil {
+		t.Errorf("test failed - %s", err)
+	}
+	if _, err := ParseTraceParent(traceParent); err != nil {
+		t.Errorf("test failed - traceparent must be sent without exporter, got %q", traceParent)
+	}
+}
+
+func TestJSONExporter(t *testing.T) {
+	var out bytes.Buffer
+	tracer := NewTracer(NewJSONExporter(&out))
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, child := StartSpan(ctx, "child")
+	child.SetAttribute("rows", 3)
+	child.Finish()
+	child.Finish()
+	parent.Finish()
+
+	decoder := json.NewDecoder(&out)
+	var lines []map[string]interface{}
+	for decoder.More() {
+		var line map[string]interface{}
+		if err := decoder.Decode(&line); err != nil {
+			t.Fatalf("test failed - %s", err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("test failed - expected 2 spans, got %d", len(lines))
+	}
+	if lines[0]["name"] != "child" || lines[0]["parent_id"] != parent.Context.SpanID.String() {
+		t.Errorf("test failed - wrong child span %v", lines[0])
+	}
+	if _, ok := lines[1]["parent_id"]; ok {
+		t.Errorf("test failed - root span with parent %v", lines[1])
+	}
+}
@@ -0,0 +1,87 @@
+package lesson4
+
+import (
+	"fmt"
+	"strings"
+
+	"lesson4/schema"
+)
+
+// ValidationRules - ограничения на SearchRequest. Одни и те же правила проверяют и клиент перед отправкой, и сервер
+type ValidationRules struct {
+	// сколько записей можно запросить за раз, 0 - без ограничения, как у MaxOffset
+	MaxLimit int
+	// максимальное смещение, 0 - без ограничения
+	MaxOffset int
+}
+
+// DefaultValidationRules - правила, по которым работали клиент и сервер до того, как их стало можно настраивать
+var DefaultValidationRules = ValidationRules{
+	MaxLimit:  25,
+	MaxOffset: 0,
+}
+
+// ValidationError - все ошибки запроса, по одной на параметр
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, fieldErr := range e.Errors {
+		messages = append(messages, fieldErr.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Unwrap - невалидный запрос относится к тому же классу, что и 400 от внешней системы
+func (e *ValidationError) Unwrap() error {
+	return ErrBadRequest
+}
+
+// Validate проверяет все поля запроса и возвращает *ValidationError со списком ошибок или nil
+func (rules ValidationRules) Validate(req SearchRequest) error {
+	errs := []FieldError{}
+
+	if req.Limit < 0 {
+		errs = append(errs, FieldError{Field: "limit", Code: schema.CodeBadLimit, Message: "limit must be > 0"})
+	} else if rules.MaxLimit > 0 && req.Limit > rules.MaxLimit {
+		errs = append(errs, FieldError{Field: "limit", Code: schema.CodeBadLimit, Message: fmt.Sprintf("limit must be <= %d", rules.MaxLimit)})
+	}
+
+	if req.Offset < 0 {
+		errs = append(errs, FieldError{Field: "offset", Code: schema.CodeBadOffset, Message: "offset must be > 0"})
+	} else if rules.MaxOffset > 0 && req.Offset > rules.MaxOffset {
+		errs = append(errs, FieldError{Field: "offset", Code: schema.CodeBadOffset, Message: fmt.Sprintf("offset must be <= %d", rules.MaxOffset)})
+	}
+
+	if req.OrderBy != OrderByAsc && req.OrderBy != OrderByAsIs && req.OrderBy != OrderByDesc {
+		errs = append(errs, FieldError{Field: "order_by", Code: schema.CodeBadOrderBy, Message: "order_by must be -1, 0 or 1"})
+	}
+
+	if !validOrderField(req.OrderField) {
+		errs = append(errs, FieldError{Field: "order_field", Code: schema.CodeBadOrderField, Message: fmt.Sprintf("OrderFeld %s invalid", req.OrderField)})
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// ClampLimit - limit, урезанный до MaxLimit, если тот задан. Так Limit урезает клиент перед отправкой
+func (rules ValidationRules) ClampLimit(limit int) int {
+	if rules.MaxLimit > 0 && limit > rules.MaxLimit {
+		return rules.MaxLimit
+	}
+	return limit
+}
+
+// validOrderField - сортировать можно по `Id`, `Age`, `Name` в любом регистре, пустое поле значит `Name`
+func validOrderField(orderField string) bool {
+	switch strings.ToLower(orderField) {
+	case "", "id", "age", "name":
+		return true
+	}
+	return false
+}
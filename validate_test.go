@@ -0,0 +1,70 @@
+package lesson4
+
+import (
+	"testing"
+
+	"lesson4/schema"
+)
+
+func TestValidationRulesValidate(t *testing.T) {
+	rules := ValidationRules{MaxLimit: 25, MaxOffset: 100}
+	cases := []struct {
+		req    SearchRequest
+		fields []string
+	}{
+		{SearchRequest{Limit: 25, Offset: 100, OrderField: "NAME", OrderBy: OrderByAsc}, nil},
+		{SearchRequest{Limit: 0, OrderField: "age", OrderBy: OrderByDesc}, nil},
+		{SearchRequest{Limit: 26}, []string{"limit"}},
+		{SearchRequest{Limit: -1}, []string{"limit"}},
+		{SearchRequest{Offset: 101}, []string{"offset"}},
+		{SearchRequest{OrderBy: 5, OrderField: "balance"}, []string{"order_by", "order_field"}},
+	}
+	for _, c := range cases {
+		err := rules.Validate(c.req)
+		if c.fields == nil {
+			if err != nil {
+				t.Errorf("%+v: unexpected error %v", c.req, err)
+			}
+			continue
+		}
+		vErr, ok := err.(*ValidationError)
+		if !ok || len(vErr.Errors) != len(c.fields) {
+			t.Errorf("%+v: test failed - expected errors for %v, got %v", c.req, c.fields, err)
+			continue
+		}
+		for i, field := range c.fields {
+			if vErr.Errors[i].Field != field {
+				t.Errorf("%+v: test failed - expected error for %s, got %+v", c.req, field, vErr.Errors[i])
+			}
+		}
+	}
+}
+
+func TestValidationRulesNoMaxOffset(t *testing.T) {
+	if err := DefaultValidationRules.Validate(SearchRequest{Offset: 1 << 20}); err != nil {
+		t.Errorf("test failed - default rules must not limit offset, got %v", err)
+	}
+}
+
+func TestValidationRulesNoMaxLimit(t *testing.T) {
+	rules := ValidationRules{}
+	if err := rules.Validate(SearchRequest{Limit: 1 << 20}); err != nil {
+		t.Errorf("test failed - zero MaxLimit must not limit, got %v", err)
+	}
+	if limit := rules.ClampLimit(1 << 20); limit != 1<<20 {
+		t.Errorf("test failed - zero MaxLimit must not clamp, got %d", limit)
+	}
+	if limit := DefaultValidationRules.ClampLimit(100); limit != DefaultValidationRules.MaxLimit {
+		t.Errorf("test failed - limit must be clamped to %d, got %d", DefaultValidationRules.MaxLimit, limit)
+	}
+}
+
+func TestValidationErrorMessage(t *testing.T) {
+	err := &ValidationError{Errors: []FieldError{
+		{Field: "limit", Code: schema.CodeBadLimit, Message: "limit must be > 0"},
+		{Field: "offset", Code: schema.CodeBadOffset, Message: "offset must be > 0"},
+	}}
+	if err.Error() != "limit must be > 0; offset must be > 0" {
+		t.Errorf("test failed - wrong message %q", err.Error())
+	}
+}